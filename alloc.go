@@ -1,14 +1,16 @@
 package cgoalloc
 
-/*
-#include <stdlib.h>
-*/
-import "C"
-import "unsafe"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
 
 // Allocator is the base interface of cgoalloc- libraries that want to make use of cgoalloc should arrange for their
 // methods to accept an Allocator at runtime and use the interface's Malloc/Free to interact with memory.  (cgoalloc.CString
-// and cgoalloc.CBytes provide similar functionality to their C.* equivalents, but accept an Allocator).
+// and cgoalloc.CBytes provide similar functionality to their C.* equivalents, but accept an Allocator.  cgoalloc.New,
+// cgoalloc.MakeSlice and cgoalloc.CopySlice do the same for typed values).
 //
 // Executable packages that want to make use of cgoalloc should initialize one or more implementation of Allocator and use
 // them- when finished with an Allocator it's useful to call Destroy.  This will ensure that any C memory pages will be
@@ -24,32 +26,157 @@ type Allocator interface {
 	Destroy() error
 }
 
-// DefaultAllocator is an Allocator implementation that just calls C.malloc/C.free
-type DefaultAllocator struct {}
+// Errors returned by TryMalloc.  Allocators wrap these with more detail, so they should be checked for with errors.Is.
+var (
+	// ErrOutOfMemory means the allocator, or the memory underneath it, has no room for the allocation
+	ErrOutOfMemory = errors.New("cgoalloc: out of memory")
+	// ErrTooLarge means the allocation is larger than the allocator could ever serve
+	ErrTooLarge = errors.New("cgoalloc: requested allocation is too large")
+	// ErrDestroyed means the allocator has already been destroyed
+	ErrDestroyed = errors.New("cgoalloc: allocator has been destroyed")
+)
 
-func (a *DefaultAllocator) Malloc(size int) unsafe.Pointer {
-	return C.malloc(C.size_t(size))
+// TryAllocator is implemented by Allocators which can report why an allocation failed, rather than returning nil or
+// panicking the way their Malloc does.  All of the Allocators in this package implement it.
+type TryAllocator interface {
+	// TryMalloc is equivalent to Malloc, but returns an error wrapping ErrOutOfMemory, ErrTooLarge or ErrDestroyed
+	// when the allocation can't be made
+	TryMalloc(size int) (unsafe.Pointer, error)
 }
 
-func (a *DefaultAllocator) Free(pointer unsafe.Pointer) {
-	C.free(pointer)
+// TryMalloc allocates size bytes with the provided Allocator, returning an error instead of a nil pointer or a panic
+// if the allocation fails.  If the Allocator is a TryAllocator, its TryMalloc method is used- otherwise, Malloc is
+// called, and a nil result for a nonzero size is reported as ErrOutOfMemory.
+func TryMalloc(allocator Allocator, size int) (unsafe.Pointer, error) {
+	if tryAllocator, ok := allocator.(TryAllocator); ok {
+		return tryAllocator.TryMalloc(size)
+	}
+
+	ptr := allocator.Malloc(size)
+	if ptr == nil && size > 0 {
+		return nil, ErrOutOfMemory
+	}
+	return ptr, nil
+}
+
+// Reallocator is implemented by Allocators which can resize an existing allocation more cheaply than a Malloc, copy and
+// Free would.  The Realloc helper will make use of it when it's available.
+type Reallocator interface {
+	// Realloc is equivalent to C.realloc
+	Realloc(pointer unsafe.Pointer, size int) unsafe.Pointer
+}
+
+// Callocator is implemented by Allocators which can allocate zeroed memory more cheaply than a Malloc followed by
+// zeroing the buffer
+type Callocator interface {
+	// Calloc is equivalent to C.calloc
+	Calloc(count, size int) unsafe.Pointer
+}
+
+// Calloc allocates a zeroed buffer of count elements of size bytes each using the provided Allocator.  If the
+// Allocator is a Callocator, its Calloc method is used, which lets C.calloc skip zeroing memory that's already zero-
+// otherwise, the buffer is allocated with Malloc and zeroed.  Calloc panics if count*size is negative or overflows.
+func Calloc(allocator Allocator, count, size int) unsafe.Pointer {
+	if count < 0 || size < 0 || (size > 0 && count > math.MaxInt/size) {
+		panic(fmt.Sprintf("cgoalloc: invalid Calloc size %d * %d", count, size))
+	}
+
+	if callocator, ok := allocator.(Callocator); ok {
+		return callocator.Calloc(count, size)
+	}
+
+	ptr := allocator.Malloc(count * size)
+	if ptr != nil {
+		clear(unsafe.Slice((*byte)(ptr), count*size))
+	}
+	return ptr
+}
+
+// CallocE is equivalent to Calloc, but returns an error instead of a nil pointer if the allocation fails
+func CallocE(allocator Allocator, count, size int) (unsafe.Pointer, error) {
+	if count < 0 || size < 0 || (size > 0 && count > math.MaxInt/size) {
+		panic(fmt.Sprintf("cgoalloc: invalid Calloc size %d * %d", count, size))
+	}
+
+	if callocator, ok := allocator.(Callocator); ok {
+		ptr := callocator.Calloc(count, size)
+		if ptr == nil && count*size > 0 {
+			return nil, ErrOutOfMemory
+		}
+		return ptr, nil
+	}
+
+	ptr, err := TryMalloc(allocator, count*size)
+	if err != nil {
+		return nil, err
+	}
+	if ptr != nil {
+		clear(unsafe.Slice((*byte)(ptr), count*size))
+	}
+	return ptr, nil
 }
 
-func (a *DefaultAllocator) Destroy() error {return nil }
+// Realloc resizes a buffer of oldSize bytes that was allocated with the provided Allocator, and returns a pointer to
+// the resized buffer, which contains the first min(oldSize, newSize) bytes of the original.  If the Allocator is a
+// Reallocator, its Realloc method is used- otherwise, a new buffer is allocated, the contents are copied over, and the
+// old buffer is freed.  A nil pointer is simply allocated.  It panics if the allocation fails- use TryRealloc to handle
+// that instead.
+func Realloc(allocator Allocator, pointer unsafe.Pointer, oldSize, newSize int) unsafe.Pointer {
+	newPointer, err := TryRealloc(allocator, pointer, oldSize, newSize)
+	if err != nil {
+		panic(err)
+	}
+	return newPointer
+}
+
+// TryRealloc is equivalent to Realloc, but returns an error instead of panicking if the allocation fails.  When it
+// fails, the original buffer is left untouched and still belongs to the caller, just as with C.realloc.
+func TryRealloc(allocator Allocator, pointer unsafe.Pointer, oldSize, newSize int) (unsafe.Pointer, error) {
+	if pointer == nil {
+		return TryMalloc(allocator, newSize)
+	}
+
+	reallocator, canRealloc := allocator.(Reallocator)
+	if canRealloc {
+		newPointer := reallocator.Realloc(pointer, newSize)
+		if newPointer == nil && newSize > 0 {
+			return nil, ErrOutOfMemory
+		}
+		return newPointer, nil
+	}
 
-// CString is equivalent to C.CString, but accepts an Allocator to manage memory allocation
-func CString(allocator Allocator, str string) *C.char {
-	ptr := allocator.Malloc(len(str)+1)
-	ptrArray := (*[1<<30]byte)(ptr)
-	copy(ptrArray[:], str)
-	ptrArray[len(str)] = 0
-	return (*C.char)(ptr)
+	newPointer, err := TryMalloc(allocator, newSize)
+	if err != nil {
+		return nil, err
+	}
+
+	copySize := oldSize
+	if newSize < copySize {
+		copySize = newSize
+	}
+	copy(unsafe.Slice((*byte)(newPointer), copySize), unsafe.Slice((*byte)(pointer), copySize))
+	allocator.Free(pointer)
+
+	return newPointer, nil
 }
 
-// CBytes is equivalent to C.CBytes, but accepts an Allocator to manage memory allocation
+// CBytes is equivalent to C.CBytes, but accepts an Allocator to manage memory allocation.  It panics if the allocation
+// fails- use CBytesE to handle that instead.
 func CBytes(allocator Allocator, b []byte) unsafe.Pointer {
-	ptr := allocator.Malloc(len(b))
-	ptrArray := (*[1<<30]byte)(ptr)
-	copy(ptrArray[:], b)
+	ptr, err := CBytesE(allocator, b)
+	if err != nil {
+		panic(err)
+	}
 	return ptr
 }
+
+// CBytesE is equivalent to CBytes, but returns an error instead of panicking if the allocation fails
+func CBytesE(allocator Allocator, b []byte) (unsafe.Pointer, error) {
+	ptr, err := TryMalloc(allocator, len(b))
+	if err != nil {
+		return nil, err
+	}
+
+	copy(GoBytesView(ptr, len(b)), b)
+	return ptr, nil
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+
+package cgoalloc
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// DefaultAllocator is an Allocator implementation that just calls C.malloc/C.free
+type DefaultAllocator struct {}
+
+func (a *DefaultAllocator) Malloc(size int) unsafe.Pointer {
+	return C.malloc(C.size_t(size))
+}
+
+func (a *DefaultAllocator) Free(pointer unsafe.Pointer) {
+	C.free(pointer)
+}
+
+func (a *DefaultAllocator) Destroy() error {return nil }
+
+func (a *DefaultAllocator) Realloc(pointer unsafe.Pointer, size int) unsafe.Pointer {
+	return C.realloc(pointer, C.size_t(size))
+}
+
+func (a *DefaultAllocator) Calloc(count, size int) unsafe.Pointer {
+	return C.calloc(C.size_t(count), C.size_t(size))
+}
+
+func (a *DefaultAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	ptr := C.malloc(C.size_t(size))
+	if ptr == nil && size > 0 {
+		return nil, ErrOutOfMemory
+	}
+	return ptr, nil
+}
+
+// CString is equivalent to C.CString, but accepts an Allocator to manage memory allocation.  It panics if the
+// allocation fails- use CStringE to handle that instead.
+func CString(allocator Allocator, str string) *C.char {
+	ptr, err := CStringE(allocator, str)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+// CStringE is equivalent to CString, but returns an error instead of panicking if the allocation fails
+func CStringE(allocator Allocator, str string) (*C.char, error) {
+	ptr, err := mallocCString(allocator, str)
+	return (*C.char)(ptr), err
+}
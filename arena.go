@@ -2,6 +2,8 @@ package cgoalloc
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 	"unsafe"
 )
 
@@ -16,6 +18,7 @@ type ArenaAllocator struct {
 	inner Allocator
 
 	allocations []unsafe.Pointer
+	destroyed   bool
 }
 
 func CreateArenaAllocator(inner Allocator) *ArenaAllocator {
@@ -26,11 +29,29 @@ func CreateArenaAllocator(inner Allocator) *ArenaAllocator {
 }
 
 func (a *ArenaAllocator) Malloc(size int) unsafe.Pointer {
+	if a.destroyed {
+		panic(fmt.Errorf("arenaallocator: %w", ErrDestroyed))
+	}
+
 	alloc := a.inner.Malloc(size)
 	a.allocations = append(a.allocations, alloc)
 	return alloc
 }
 
+func (a *ArenaAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("arenaallocator: %w", ErrDestroyed)
+	}
+
+	alloc, err := TryMalloc(a.inner, size)
+	if err != nil {
+		return nil, err
+	}
+
+	a.allocations = append(a.allocations, alloc)
+	return alloc, nil
+}
+
 func (a *ArenaAllocator) Free(ptr unsafe.Pointer) {
 	allocIndex := -1
 	for i := 0; i < len(a.allocations); i++ {
@@ -55,13 +76,64 @@ func (a *ArenaAllocator) Free(ptr unsafe.Pointer) {
 func (a *ArenaAllocator) FreeAll() {
 	for i := 0; i < len(a.allocations); i++ {
 		a.inner.Free(a.allocations[i])
+		a.allocations[i] = nil
 	}
-	a.allocations = nil
+	a.allocations = a.allocations[:0]
 }
 
 func (a *ArenaAllocator) Destroy() error {
+	if a.destroyed {
+		return nil
+	}
 	if len(a.allocations) > 0 {
 		return errors.New("arenaallocator: attempted to Destroy but not all allocations have been freed")
 	}
+
+	a.destroyed = true
 	return a.inner.Destroy()
 }
+
+var arenaPool = sync.Pool{
+	New: func() interface{} {
+		return CreateArenaAllocator(nil)
+	},
+}
+
+// WithArena creates an ArenaAllocator on top of inner and passes it to fn.  Once fn has returned, the arena is freed
+// with FreeAll and then retired, even if fn panics- in which case the panic continues on afterwards, and a panic during
+// cleanup won't replace it.  Unlike ArenaAllocator.Destroy, this leaves inner alive, so a long-lived allocator can be
+// shared by any number of WithArena calls.  WithArena returns the error returned by fn.
+//
+// Arenas are drawn from a pool of reusable ArenaAllocators, so the arena passed to fn must not be retained once fn
+// has returned.
+func WithArena(inner Allocator, fn func(a *ArenaAllocator) error) error {
+	arena := arenaPool.Get().(*ArenaAllocator)
+	arena.inner = inner
+	arena.allocations = arena.allocations[:0]
+	arena.destroyed = false
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			// Deferred first so it runs last, even if the cleanup panics
+			defer panic(recovered)
+			releaseArena(arena)
+			return
+		}
+
+		releaseArena(arena)
+	}()
+
+	return fn(arena)
+}
+
+// releaseArena frees everything allocated through an arena taken from the pool, marks it destroyed without destroying
+// its inner Allocator, and returns it to the pool
+func releaseArena(arena *ArenaAllocator) {
+	defer func() {
+		arena.inner = nil
+		arena.destroyed = true
+		arenaPool.Put(arena)
+	}()
+
+	arena.FreeAll()
+}
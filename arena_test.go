@@ -1,14 +1,17 @@
-package cgoalloc
+package cgoalloc_test
 
 import (
+	"errors"
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
 func TestArena_FreeAll(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc := CreateArenaAllocator(testAlloc)
-	defer require.NoError(t, alloc.Destroy())
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc := cgoalloc.CreateArenaAllocator(testAlloc)
+	defer func() { require.NoError(t, alloc.Destroy()) }()
 
 	_ = alloc.Malloc(8)
 	_ = alloc.Malloc(12)
@@ -25,9 +28,9 @@ func TestArena_FreeAll(t *testing.T) {
 }
 
 func TestArena_PreFreeOne(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc := CreateArenaAllocator(testAlloc)
-	defer require.NoError(t, alloc.Destroy())
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc := cgoalloc.CreateArenaAllocator(testAlloc)
+	defer func() { require.NoError(t, alloc.Destroy()) }()
 
 	a1 := alloc.Malloc(8)
 	_ = alloc.Malloc(12)
@@ -43,3 +46,69 @@ func TestArena_PreFreeOne(t *testing.T) {
 	require.ElementsMatch(t, allocs, []int{8, 12, 16})
 	require.ElementsMatch(t, frees, []int{8, 12, 16})
 }
+
+func TestArena_WithArena(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	err := cgoalloc.WithArena(testAlloc, func(a *cgoalloc.ArenaAllocator) error {
+		_ = a.Malloc(8)
+		_ = a.Malloc(12)
+		return errors.New("arena test")
+	})
+	require.EqualError(t, err, "arena test")
+
+	allocs, frees := testAlloc.Record()
+	require.ElementsMatch(t, allocs, []int{8, 12})
+	require.ElementsMatch(t, frees, []int{8, 12})
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestArena_WithArenaPanic(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	require.PanicsWithValue(t, "arena test", func() {
+		_ = cgoalloc.WithArena(testAlloc, func(a *cgoalloc.ArenaAllocator) error {
+			_ = a.Malloc(8)
+			_ = a.Malloc(16)
+			panic("arena test")
+		})
+	})
+
+	allocs, frees := testAlloc.Record()
+	require.ElementsMatch(t, allocs, []int{8, 16})
+	require.ElementsMatch(t, frees, []int{8, 16})
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestArena_WithArenaSharedInner(t *testing.T) {
+	inner, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 4096, 64, 8)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = cgoalloc.WithArena(inner, func(a *cgoalloc.ArenaAllocator) error {
+			_, err := a.TryMalloc(16)
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	// inner is still usable once the arenas are done with it
+	ptr, err := inner.TryMalloc(16)
+	require.NoError(t, err)
+	inner.Free(ptr)
+	require.NoError(t, inner.Destroy())
+}
+
+func TestArena_WithArenaPanicDuringCleanup(t *testing.T) {
+	budget, err := cgoalloc.CreateBudgetAllocator(createInnerAllocator(t), cgoalloc.BudgetOptions{})
+	require.NoError(t, err)
+
+	// FreeAll will panic when it frees ptr a second time, but it's fn's panic that should come out of WithArena
+	require.PanicsWithValue(t, "arena test", func() {
+		_ = cgoalloc.WithArena(budget, func(a *cgoalloc.ArenaAllocator) error {
+			budget.Free(a.Malloc(8))
+			panic("arena test")
+		})
+	})
+	require.Equal(t, 0, budget.LiveAllocations())
+}
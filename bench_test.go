@@ -1,3 +1,5 @@
+//go:build cgo
+
 package cgoalloc
 
 import (
@@ -8,7 +10,7 @@ import (
 
 func BenchmarkDefaultTemporaryData(b *testing.B) {
 	alloc := &DefaultAllocator{}
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	for i := 0; i < b.N; i++ {
 		a := alloc.Malloc(64)
@@ -21,7 +23,7 @@ func BenchmarkFBATemporaryData(b *testing.B) {
 	if err != nil {
 		b.FailNow()
 	}
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	for i := 0; i < b.N; i++ {
 		a := alloc.Malloc(64)
@@ -34,7 +36,7 @@ func BenchmarkArenaTemporaryData(b *testing.B) {
 	if err != nil {
 		b.FailNow()
 	}
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	for i := 0; i < b.N/2; i++ {
 		arena := CreateArenaAllocator(alloc)
@@ -46,7 +48,7 @@ func BenchmarkArenaTemporaryData(b *testing.B) {
 
 func BenchmarkDefaultGrowShrink(b *testing.B) {
 	alloc := &DefaultAllocator{}
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	ptrs := make([]unsafe.Pointer, b.N, b.N)
 
@@ -64,7 +66,7 @@ func BenchmarkFBAGrowShrink(b *testing.B) {
 	if err != nil {
 		b.FailNow()
 	}
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	ptrs := make([]unsafe.Pointer, b.N, b.N)
 
@@ -89,7 +91,7 @@ func BenchmarkMultilayerTemporaryData(b *testing.B) {
 	}
 	alloc := CreateFallbackAllocator(higherLevel, defAlloc)
 	alloc = CreateFallbackAllocator(lowerLevel, alloc)
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	for i := 0; i < b.N; i++ {
 		size := 4
@@ -115,7 +117,7 @@ func BenchmarkMultilayerGrowShrink(b *testing.B) {
 	}
 	alloc := CreateFallbackAllocator(higherLevel, defAlloc)
 	alloc = CreateFallbackAllocator(lowerLevel, alloc)
-	defer require.NoError(b, alloc.Destroy())
+	defer func() { require.NoError(b, alloc.Destroy()) }()
 
 	ptrs := make([]unsafe.Pointer, b.N, b.N)
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,267 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+type buddyRegion struct {
+	region unsafe.Pointer
+	start  unsafe.Pointer
+}
+
+type buddyBlock struct {
+	region *buddyRegion
+	offset uintptr
+	order  uint
+}
+
+// buddyFreeList holds the free blocks of a single order.  Blocks are popped from the end of the slice, and the index
+// lets a block be removed from the middle when its buddy is freed.
+type buddyFreeList struct {
+	blocks []buddyBlock
+	index  map[uintptr]int
+}
+
+func (l *buddyFreeList) push(address uintptr, block buddyBlock) {
+	l.index[address] = len(l.blocks)
+	l.blocks = append(l.blocks, block)
+}
+
+func (l *buddyFreeList) pop(blockAddress func(buddyBlock) uintptr) (buddyBlock, bool) {
+	if len(l.blocks) == 0 {
+		return buddyBlock{}, false
+	}
+
+	last := len(l.blocks) - 1
+	block := l.blocks[last]
+	l.blocks = l.blocks[:last]
+	delete(l.index, blockAddress(block))
+	return block, true
+}
+
+// remove removes the block at address from the list, and returns false if it wasn't there
+func (l *buddyFreeList) remove(address uintptr, blockAddress func(buddyBlock) uintptr) bool {
+	i, ok := l.index[address]
+	if !ok {
+		return false
+	}
+	delete(l.index, address)
+
+	// Fill the hole with the last block
+	last := len(l.blocks) - 1
+	if i != last {
+		l.blocks[i] = l.blocks[last]
+		l.index[blockAddress(l.blocks[i])] = i
+	}
+	l.blocks = l.blocks[:last]
+	return true
+}
+
+// BuddyAllocator is an Allocator implementation which serves Malloc calls with power-of-two sized blocks carved out
+// of large regions allocated from an inner Allocator.  Each region is the size of the largest block order.  When a
+// Malloc call is made, the smallest free block that can hold the request is split in half repeatedly until it is the
+// right size, and when a block is freed, it is merged with its buddy- the other half of the block it was split from-
+// for as long as that buddy is also free.
+//
+// Blocks are always aligned to the minimum block size.  Malloc calls larger than the maximum block size will panic.
+// When a Free results in an entire region being free, the region is freed, unless it is the only one the allocator
+// has.  BuddyAllocator is a TierAllocator, so it can be used as a tier of a FallbackAllocator.
+type BuddyAllocator struct {
+	inner Allocator
+
+	minOrder uint
+	maxOrder uint
+
+	regions    map[*buddyRegion]struct{}
+	freeBlocks []buddyFreeList
+	usedBlocks map[uintptr]buddyBlock
+
+	destroyed bool
+}
+
+// CreateBuddyAllocator creates a new BuddyAllocator with the provided properties.
+// inner - Regions are created using this Allocator
+// minOrder - The smallest block size is 2^minOrder bytes.  All blocks are aligned to this size.
+// maxOrder - The largest block size, and the size of each region, is 2^maxOrder bytes.  Must be at least minOrder.
+func CreateBuddyAllocator(inner Allocator, minOrder, maxOrder uint) (*BuddyAllocator, error) {
+	if minOrder > maxOrder {
+		return nil, errors.New("buddyallocator: minorder must not be greater than maxorder")
+	}
+	if maxOrder >= 48 {
+		return nil, errors.New("buddyallocator: maxorder must be less than 48")
+	}
+
+	freeBlocks := make([]buddyFreeList, maxOrder-minOrder+1)
+	for i := range freeBlocks {
+		freeBlocks[i].index = make(map[uintptr]int)
+	}
+
+	return &BuddyAllocator{
+		inner: inner,
+
+		minOrder: minOrder,
+		maxOrder: maxOrder,
+
+		regions:    make(map[*buddyRegion]struct{}),
+		freeBlocks: freeBlocks,
+		usedBlocks: make(map[uintptr]buddyBlock),
+	}, nil
+}
+
+// MaxAllocSize returns the largest block size- Malloc calls requesting more than this will panic
+func (a *BuddyAllocator) MaxAllocSize() int { return 1 << a.maxOrder }
+
+// Owns returns true if ptr is located in one of this allocator's regions
+func (a *BuddyAllocator) Owns(ptr unsafe.Pointer) bool {
+	if _, ok := a.usedBlocks[uintptr(ptr)]; ok {
+		return true
+	}
+
+	regionSize := uintptr(1) << a.maxOrder
+	for region := range a.regions {
+		start := uintptr(region.start)
+		if uintptr(ptr) >= start && uintptr(ptr) < start+regionSize {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *BuddyAllocator) blockAddress(block buddyBlock) uintptr {
+	return uintptr(block.region.start) + block.offset
+}
+
+func (a *BuddyAllocator) pushFreeBlock(block buddyBlock) {
+	a.freeBlocks[block.order-a.minOrder].push(a.blockAddress(block), block)
+}
+
+func (a *BuddyAllocator) popFreeBlock(order uint) (buddyBlock, bool) {
+	return a.freeBlocks[order-a.minOrder].pop(a.blockAddress)
+}
+
+func (a *BuddyAllocator) allocateRegion() (buddyBlock, error) {
+	minBlockSize := uintptr(1) << a.minOrder
+	regionPtr, err := TryMalloc(a.inner, int(uintptr(1)<<a.maxOrder+minBlockSize))
+	if err != nil {
+		return buddyBlock{}, err
+	}
+	padding := (minBlockSize - uintptr(regionPtr)%minBlockSize) % minBlockSize
+
+	region := &buddyRegion{region: regionPtr, start: unsafe.Add(regionPtr, padding)}
+	a.regions[region] = struct{}{}
+
+	return buddyBlock{region: region, order: a.maxOrder}, nil
+}
+
+func (a *BuddyAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *BuddyAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("buddyallocator: %w", ErrDestroyed)
+	}
+	if size > a.MaxAllocSize() {
+		return nil, fmt.Errorf("buddyallocator: requested allocation larger than maximum block size: %w", ErrTooLarge)
+	}
+
+	order := a.minOrder
+	if size > 1 {
+		if sizeOrder := uint(bits.Len(uint(size - 1))); sizeOrder > order {
+			order = sizeOrder
+		}
+	}
+
+	// Find the smallest free block that fits, or allocate a new region
+	var block buddyBlock
+	found := false
+	for searchOrder := order; searchOrder <= a.maxOrder && !found; searchOrder++ {
+		block, found = a.popFreeBlock(searchOrder)
+	}
+	if !found {
+		var err error
+		block, err = a.allocateRegion()
+		if err != nil {
+			return nil, fmt.Errorf("buddyallocator: failed to allocate a region: %w", err)
+		}
+	}
+
+	// Split it down to size
+	for block.order > order {
+		block.order--
+		a.pushFreeBlock(buddyBlock{region: block.region, offset: block.offset + (1 << block.order), order: block.order})
+	}
+
+	ptr := unsafe.Add(block.region.start, block.offset)
+	a.usedBlocks[uintptr(ptr)] = block
+	return ptr, nil
+}
+
+func (a *BuddyAllocator) Free(ptr unsafe.Pointer) {
+	if !a.TryFree(ptr) {
+		panic("buddyallocator: attempted to free a pointer which had not been allocated with this allocator")
+	}
+}
+
+// TryFree frees ptr and returns true if it is located in one of this allocator's regions, or returns false otherwise.
+// It panics if ptr is in one of the regions, but is not a live allocation.
+func (a *BuddyAllocator) TryFree(ptr unsafe.Pointer) bool {
+	block, ok := a.usedBlocks[uintptr(ptr)]
+	if !ok {
+		if a.Owns(ptr) {
+			panic(fmt.Sprintf("buddyallocator: attempted to free %p, which is not a live allocation", ptr))
+		}
+		return false
+	}
+	delete(a.usedBlocks, uintptr(ptr))
+
+	// Merge with our buddy for as long as it's free
+	for block.order < a.maxOrder {
+		buddy := buddyBlock{region: block.region, offset: block.offset ^ (1 << block.order), order: block.order}
+		if !a.freeBlocks[block.order-a.minOrder].remove(a.blockAddress(buddy), a.blockAddress) {
+			break
+		}
+
+		if buddy.offset < block.offset {
+			block.offset = buddy.offset
+		}
+		block.order++
+	}
+
+	if block.order == a.maxOrder && len(a.regions) > 1 {
+		delete(a.regions, block.region)
+		a.inner.Free(block.region.region)
+		return true
+	}
+
+	a.pushFreeBlock(block)
+	return true
+}
+
+func (a *BuddyAllocator) Destroy() error {
+	if a.destroyed {
+		return nil
+	}
+
+	if len(a.usedBlocks) > 0 {
+		return errors.New("buddyallocator: attempted to Destroy, but not all allocations had been freed")
+	}
+
+	for region := range a.regions {
+		a.inner.Free(region.region)
+	}
+	a.regions = make(map[*buddyRegion]struct{})
+	for i := range a.freeBlocks {
+		a.freeBlocks[i] = buddyFreeList{index: make(map[uintptr]int)}
+	}
+	a.destroyed = true
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func TestBuddy_SplitAndMerge(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateBuddyAllocator(testAlloc, 4, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(16)
+	a2 := alloc.Malloc(10)
+	a3 := alloc.Malloc(60)
+	require.Zero(t, uintptr(a1)%16)
+	require.Equal(t, uintptr(16), uintptr(a2)-uintptr(a1))
+	require.Equal(t, uintptr(64), uintptr(a3)-uintptr(a1))
+
+	alloc.Free(a1)
+	alloc.Free(a2)
+
+	// a1 and a2 have merged back into a 32 byte block, and then with the free 32 byte block beside them
+	require.Equal(t, a1, alloc.Malloc(64))
+	alloc.Free(a1)
+	alloc.Free(a3)
+
+	require.Equal(t, a1, alloc.Malloc(256))
+	require.Panics(t, func() {
+		_ = alloc.Malloc(257)
+	})
+
+	a4 := alloc.Malloc(1)
+	a5 := alloc.Malloc(1)
+	require.True(t, alloc.Owns(a4))
+	require.True(t, alloc.TryFree(a4))
+
+	// a4 is still inside the allocator's memory, so the allocator still owns it, but freeing it twice is an error
+	require.True(t, alloc.Owns(a4))
+	require.Panics(t, func() {
+		alloc.TryFree(a4)
+	})
+	var outside [8]byte
+	require.False(t, alloc.Owns(unsafe.Pointer(&outside)))
+	require.False(t, alloc.TryFree(unsafe.Pointer(&outside)))
+	alloc.Free(a5)
+	alloc.Free(a1)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{272, 272}, allocs)
+	require.Equal(t, []int{272}, frees)
+
+	require.NoError(t, alloc.Destroy())
+	allocs, frees = testAlloc.Record()
+	require.Equal(t, []int{272, 272}, frees)
+}
@@ -0,0 +1,366 @@
+package cgoalloc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// ErrBudgetExceeded is returned by BudgetAllocator when an allocation would take a budget past one of its limits.
+// It wraps ErrOutOfMemory, so code that only checks for ErrOutOfMemory will handle it as well.
+var ErrBudgetExceeded = fmt.Errorf("cgoalloc: budget exceeded: %w", ErrOutOfMemory)
+
+// BudgetExceededBehavior determines what a BudgetAllocator does when an allocation would exceed its budget
+type BudgetExceededBehavior int
+
+const (
+	// BudgetFail causes Malloc to return nil, and TryMalloc to return ErrBudgetExceeded
+	BudgetFail BudgetExceededBehavior = iota
+	// BudgetBlock causes Malloc to wait until enough memory has been freed.  MallocContext can be used to give up
+	// waiting when a context is cancelled.
+	BudgetBlock
+	// BudgetCallback calls BudgetOptions.OnExceeded, which may free memory, and then tries once more before failing
+	// as with BudgetFail
+	BudgetCallback
+)
+
+// BudgetOptions describes the limits of a BudgetAllocator
+type BudgetOptions struct {
+	// MaxBytes is the maximum number of bytes which may be live at once.  0 means no limit.
+	MaxBytes int
+	// MaxAllocations is the maximum number of allocations which may be live at once.  0 means no limit.
+	MaxAllocations int
+	// Behavior determines what happens when an allocation would exceed the budget
+	Behavior BudgetExceededBehavior
+	// OnExceeded is called with the requested size when an allocation would exceed the budget and Behavior is
+	// BudgetCallback.  No locks are held while it runs, so it may free memory through the budget.
+	OnExceeded func(size int)
+}
+
+type pressureHook struct {
+	level int
+	fn    func()
+}
+
+// budgetTree holds the state shared by a root BudgetAllocator and all of its sub-budgets
+type budgetTree struct {
+	lock     sync.Mutex
+	freeCond *sync.Cond
+}
+
+// BudgetAllocator is an Allocator implementation which sits on top of another Allocator and enforces a limit on the
+// number of bytes and allocations which may be live at once.  What happens when an allocation would exceed the limit
+// is determined by the BudgetExceededBehavior in its BudgetOptions.
+//
+// SubBudget splits a budget among several consumers: allocations made through a sub-budget count against the
+// sub-budget and every budget above it, so a subsystem can be capped without being able to starve its siblings of the
+// parent's budget.  When an allocation would exceed any budget in the chain, the behavior of the budget it was made
+// through is used.  Pointers must be freed through the same budget they were allocated from.
+//
+// OnPressure registers soft limits below the hard ones, so that caches built on a budget can shed memory before
+// allocations start failing.  A BudgetAllocator with no limits at all can be used purely to track live bytes and fire
+// pressure hooks.
+//
+// BudgetAllocator is safe for concurrent use if the inner Allocator is.
+type BudgetAllocator struct {
+	inner  Allocator
+	parent *BudgetAllocator
+	tree   *budgetTree
+	opts   BudgetOptions
+
+	liveBytes       int
+	liveAllocations int
+	committedBytes  int
+	allocSizes      map[unsafe.Pointer]int
+	pressureHooks   []pressureHook
+	destroyed       bool
+}
+
+func validateBudgetOptions(opts BudgetOptions) error {
+	if opts.MaxBytes < 0 || opts.MaxAllocations < 0 {
+		return errors.New("budgetallocator: limits must not be negative")
+	}
+	if opts.Behavior == BudgetCallback && opts.OnExceeded == nil {
+		return errors.New("budgetallocator: BudgetCallback requires an OnExceeded callback")
+	}
+	return nil
+}
+
+// CreateBudgetAllocator creates a new BudgetAllocator with the provided properties.
+// inner - Allocations are made using this Allocator
+// opts - The limits of the budget and what to do when they are exceeded
+func CreateBudgetAllocator(inner Allocator, opts BudgetOptions) (*BudgetAllocator, error) {
+	if err := validateBudgetOptions(opts); err != nil {
+		return nil, err
+	}
+
+	tree := &budgetTree{}
+	tree.freeCond = sync.NewCond(&tree.lock)
+
+	return &BudgetAllocator{
+		inner: inner,
+		tree:  tree,
+		opts:  opts,
+
+		allocSizes: make(map[unsafe.Pointer]int),
+	}, nil
+}
+
+// SubBudget creates a new BudgetAllocator which allocates from this budget's inner Allocator, and whose allocations
+// count against both its own limits and those of this budget.  Destroying a sub-budget does not destroy the inner
+// Allocator.
+func (a *BudgetAllocator) SubBudget(opts BudgetOptions) (*BudgetAllocator, error) {
+	if err := validateBudgetOptions(opts); err != nil {
+		return nil, err
+	}
+
+	return &BudgetAllocator{
+		inner:  a.inner,
+		parent: a,
+		tree:   a.tree,
+		opts:   opts,
+
+		allocSizes: make(map[unsafe.Pointer]int),
+	}, nil
+}
+
+// tooLarge returns true if size could never fit in this budget or any above it
+func (a *BudgetAllocator) tooLarge(size int) bool {
+	for budget := a; budget != nil; budget = budget.parent {
+		if budget.opts.MaxBytes > 0 && size > budget.opts.MaxBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// isDestroyed returns true if this budget or any budget above it has been destroyed.  The tree lock must be held.
+func (a *BudgetAllocator) isDestroyed() bool {
+	for budget := a; budget != nil; budget = budget.parent {
+		if budget.destroyed {
+			return true
+		}
+	}
+	return false
+}
+
+// fits returns true if an allocation of the provided size fits in this budget and every budget above it.  The tree
+// lock must be held.
+func (a *BudgetAllocator) fits(size int) bool {
+	for budget := a; budget != nil; budget = budget.parent {
+		if budget.opts.MaxBytes > 0 && budget.liveBytes+size > budget.opts.MaxBytes {
+			return false
+		}
+		if budget.opts.MaxAllocations > 0 && budget.liveAllocations+1 > budget.opts.MaxAllocations {
+			return false
+		}
+	}
+	return true
+}
+
+// charge adds an allocation of the provided size to this budget and every budget above it.  A negative size and count
+// remove one.  The tree lock must be held.
+func (a *BudgetAllocator) charge(size, count int) {
+	for budget := a; budget != nil; budget = budget.parent {
+		budget.liveBytes += size
+		budget.liveAllocations += count
+	}
+}
+
+// commit adds size, which may be negative, to the bytes this budget and every budget above it have actually handed
+// out.  Unlike the live bytes, these don't include reservations whose inner allocation is still in flight, so a
+// reservation that is rolled back never fires a pressure hook.  The pressure hooks whose level was crossed on the way
+// up are returned, so they can be called once the tree lock is released.  The tree lock must be held.
+func (a *BudgetAllocator) commit(size int) []func() {
+	var crossed []func()
+	for budget := a; budget != nil; budget = budget.parent {
+		before := budget.committedBytes
+		budget.committedBytes += size
+
+		for _, hook := range budget.pressureHooks {
+			if before < hook.level && budget.committedBytes >= hook.level {
+				crossed = append(crossed, hook.fn)
+			}
+		}
+	}
+	return crossed
+}
+
+// OnPressure registers fn to be called whenever an allocation takes the committed bytes of this budget- the bytes of
+// allocations that have succeeded through it or its sub-budgets- from below level to level or above.  Unlike
+// LiveBytes, committed bytes don't include allocations still in flight in the inner Allocator, so one that fails there
+// never triggers fn, and its bytes don't count towards the level.  fn is called after the allocation has been made,
+// with no locks held, so it may free memory through the budget.  It will be called again the next time the level is
+// crossed, once enough memory has been freed to fall back below it.
+func (a *BudgetAllocator) OnPressure(level int, fn func()) {
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	a.pressureHooks = append(a.pressureHooks, pressureHook{level: level, fn: fn})
+}
+
+// reserve charges an allocation of the provided size to the budget, waiting or calling OnExceeded as configured if
+// it doesn't fit.  If the inner allocation then fails, the reservation must be rolled back with release.
+func (a *BudgetAllocator) reserve(ctx context.Context, size int) error {
+	if a.tooLarge(size) {
+		return fmt.Errorf("budgetallocator: requested allocation larger than the budget: %w", ErrTooLarge)
+	}
+
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	if a.isDestroyed() {
+		return fmt.Errorf("budgetallocator: %w", ErrDestroyed)
+	}
+
+	if !a.fits(size) {
+		switch a.opts.Behavior {
+		case BudgetBlock:
+			// sync.Cond can't wait on a context, so wake everyone up when it's cancelled
+			stop := context.AfterFunc(ctx, func() {
+				a.tree.lock.Lock()
+				defer a.tree.lock.Unlock()
+				a.tree.freeCond.Broadcast()
+			})
+			defer stop()
+
+			for !a.fits(size) {
+				if err := ctx.Err(); err != nil {
+					return fmt.Errorf("budgetallocator: gave up waiting for the budget: %w", err)
+				}
+				if a.isDestroyed() {
+					return fmt.Errorf("budgetallocator: %w", ErrDestroyed)
+				}
+				a.tree.freeCond.Wait()
+			}
+		case BudgetCallback:
+			a.tree.lock.Unlock()
+			a.opts.OnExceeded(size)
+			a.tree.lock.Lock()
+
+			if a.isDestroyed() {
+				return fmt.Errorf("budgetallocator: %w", ErrDestroyed)
+			}
+			if !a.fits(size) {
+				return fmt.Errorf("budgetallocator: %w", ErrBudgetExceeded)
+			}
+		default:
+			return fmt.Errorf("budgetallocator: %w", ErrBudgetExceeded)
+		}
+	}
+
+	a.charge(size, 1)
+	return nil
+}
+
+// release rolls back a reservation whose inner allocation failed
+func (a *BudgetAllocator) release(size int) {
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	a.charge(-size, -1)
+	a.tree.freeCond.Broadcast()
+}
+
+// MallocContext is equivalent to TryMalloc, but if the budget's behavior is BudgetBlock, it stops waiting for memory
+// to be freed and returns an error wrapping ctx.Err() once ctx is done
+func (a *BudgetAllocator) MallocContext(ctx context.Context, size int) (unsafe.Pointer, error) {
+	if err := a.reserve(ctx, size); err != nil {
+		return nil, err
+	}
+
+	ptr, err := TryMalloc(a.inner, size)
+	if err != nil {
+		a.release(size)
+		return nil, err
+	}
+
+	a.tree.lock.Lock()
+	a.allocSizes[ptr] = size
+	pressureHooks := a.commit(size)
+	a.tree.lock.Unlock()
+
+	for _, hook := range pressureHooks {
+		hook()
+	}
+	return ptr, nil
+}
+
+func (a *BudgetAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	return a.MallocContext(context.Background(), size)
+}
+
+// Malloc returns nil if the allocation would exceed the budget, unless the budget's behavior is BudgetBlock, in which
+// case it waits for memory to be freed.  It panics if the allocation is larger than the budget could ever allow.
+func (a *BudgetAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if errors.Is(err, ErrOutOfMemory) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+// Free frees ptr through the inner Allocator and returns its size to the budget.  It will panic if ptr was not
+// allocated through this budget.
+func (a *BudgetAllocator) Free(ptr unsafe.Pointer) {
+	a.tree.lock.Lock()
+	size, ok := a.allocSizes[ptr]
+	delete(a.allocSizes, ptr)
+	a.tree.lock.Unlock()
+
+	if !ok {
+		panic(fmt.Sprintf("budgetallocator: attempted to free %p, which was not allocated through this budget", ptr))
+	}
+
+	a.inner.Free(ptr)
+
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	a.commit(-size)
+	a.charge(-size, -1)
+	a.tree.freeCond.Broadcast()
+}
+
+// Destroy returns an error if any allocations made through this budget or its sub-budgets are still live.  Otherwise,
+// if this is the root budget, the inner Allocator is destroyed.
+func (a *BudgetAllocator) Destroy() error {
+	a.tree.lock.Lock()
+	live := a.liveAllocations
+	destroyed := a.destroyed
+	if live == 0 {
+		a.destroyed = true
+		// Wake anyone blocked on a sub-budget, since they'll never get their memory now
+		a.tree.freeCond.Broadcast()
+	}
+	a.tree.lock.Unlock()
+
+	if live > 0 {
+		return errors.New("budgetallocator: attempted to Destroy but not all allocations have been freed")
+	}
+
+	if destroyed || a.parent != nil {
+		return nil
+	}
+	return a.inner.Destroy()
+}
+
+// LiveBytes returns the number of bytes currently allocated through this budget and its sub-budgets
+func (a *BudgetAllocator) LiveBytes() int {
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	return a.liveBytes
+}
+
+// LiveAllocations returns the number of allocations currently live through this budget and its sub-budgets
+func (a *BudgetAllocator) LiveAllocations() int {
+	a.tree.lock.Lock()
+	defer a.tree.lock.Unlock()
+
+	return a.liveAllocations
+}
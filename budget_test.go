@@ -0,0 +1,199 @@
+package cgoalloc_test
+
+import (
+	"context"
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func createBudgetTestHeap(t *testing.T) *cgoalloctest.RecordingAllocator {
+	heap, err := cgoalloc.CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+	return cgoalloctest.CreateRecordingAllocator(t, heap)
+}
+
+func TestBudget_Fail(t *testing.T) {
+	alloc, err := cgoalloc.CreateBudgetAllocator(createBudgetTestHeap(t), cgoalloc.BudgetOptions{MaxBytes: 64, MaxAllocations: 3})
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(32)
+	a2 := alloc.Malloc(24)
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(16))
+	_, err = alloc.TryMalloc(16)
+	require.ErrorIs(t, err, cgoalloc.ErrBudgetExceeded)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	a3 := alloc.Malloc(8)
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(0))
+	require.Equal(t, 64, alloc.LiveBytes())
+	require.Equal(t, 3, alloc.LiveAllocations())
+
+	_, err = alloc.TryMalloc(65)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	require.Error(t, alloc.Destroy())
+	alloc.Free(a1)
+	alloc.Free(a2)
+	alloc.Free(a3)
+	require.Equal(t, 0, alloc.LiveBytes())
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestBudget_SubBudgets(t *testing.T) {
+	root, err := cgoalloc.CreateBudgetAllocator(createBudgetTestHeap(t), cgoalloc.BudgetOptions{MaxBytes: 100})
+	require.NoError(t, err)
+	sub1, err := root.SubBudget(cgoalloc.BudgetOptions{MaxBytes: 60})
+	require.NoError(t, err)
+	sub2, err := root.SubBudget(cgoalloc.BudgetOptions{MaxBytes: 60})
+	require.NoError(t, err)
+
+	a1 := sub1.Malloc(60)
+	require.Equal(t, unsafe.Pointer(nil), sub1.Malloc(1))
+
+	// sub2 has room of its own, but not in the root budget
+	a2 := sub2.Malloc(40)
+	require.Equal(t, unsafe.Pointer(nil), sub2.Malloc(1))
+	require.Equal(t, 100, root.LiveBytes())
+
+	require.Panics(t, func() {
+		root.Free(a1)
+	})
+
+	sub1.Free(a1)
+	require.Equal(t, 40, root.LiveBytes())
+	a3 := sub2.Malloc(20)
+
+	require.Error(t, root.Destroy())
+	sub2.Free(a2)
+	sub2.Free(a3)
+	require.NoError(t, sub1.Destroy())
+	require.NoError(t, sub2.Destroy())
+	require.NoError(t, root.Destroy())
+}
+
+func TestBudget_Block(t *testing.T) {
+	alloc, err := cgoalloc.CreateBudgetAllocator(createBudgetTestHeap(t), cgoalloc.BudgetOptions{MaxBytes: 64, Behavior: cgoalloc.BudgetBlock})
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(64)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = alloc.MallocContext(ctx, 8)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	result := make(chan unsafe.Pointer)
+	go func() {
+		result <- alloc.Malloc(32)
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("malloc should have blocked until memory was freed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	alloc.Free(a1)
+	a2 := <-result
+	require.NotEqual(t, unsafe.Pointer(nil), a2)
+
+	alloc.Free(a2)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestBudget_Callback(t *testing.T) {
+	var cached []unsafe.Pointer
+	var alloc *cgoalloc.BudgetAllocator
+	alloc, err := cgoalloc.CreateBudgetAllocator(createBudgetTestHeap(t), cgoalloc.BudgetOptions{
+		MaxBytes: 64,
+		Behavior: cgoalloc.BudgetCallback,
+		OnExceeded: func(size int) {
+			// Shed the cache to make room
+			for _, ptr := range cached {
+				alloc.Free(ptr)
+			}
+			cached = nil
+		},
+	})
+	require.NoError(t, err)
+
+	cached = append(cached, alloc.Malloc(32), alloc.Malloc(32))
+	a1 := alloc.Malloc(48)
+	require.NotEqual(t, unsafe.Pointer(nil), a1)
+	require.Len(t, cached, 0)
+
+	// Nothing left to shed
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(32))
+
+	alloc.Free(a1)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestBudget_OnPressure(t *testing.T) {
+	root, err := cgoalloc.CreateBudgetAllocator(createBudgetTestHeap(t), cgoalloc.BudgetOptions{})
+	require.NoError(t, err)
+	sub, err := root.SubBudget(cgoalloc.BudgetOptions{})
+	require.NoError(t, err)
+
+	var fired []string
+	root.OnPressure(64, func() { fired = append(fired, "root 64") })
+	root.OnPressure(128, func() { fired = append(fired, "root 128") })
+	sub.OnPressure(32, func() { fired = append(fired, "sub 32") })
+
+	a1 := root.Malloc(48)
+	require.Len(t, fired, 0)
+
+	a2 := sub.Malloc(32)
+	require.Equal(t, []string{"sub 32", "root 64"}, fired)
+
+	// Staying above the level doesn't fire again
+	a3 := root.Malloc(8)
+	require.Len(t, fired, 2)
+
+	root.Free(a3)
+	root.Free(a1)
+	a4 := root.Malloc(100)
+	require.Equal(t, []string{"sub 32", "root 64", "root 64", "root 128"}, fired)
+
+	root.Free(a4)
+	sub.Free(a2)
+	require.NoError(t, root.Destroy())
+}
+
+func TestBudget_OnPressureInnerFailure(t *testing.T) {
+	var root *cgoalloc.BudgetAllocator
+	var nested unsafe.Pointer
+	inner := cgoalloctest.CreateFaultInjectingAllocator(createBudgetTestHeap(t), cgoalloctest.FaultOptions{
+		Predicate: func(call cgoalloctest.MallocCall) bool {
+			if call.Size != 32 {
+				return false
+			}
+			// While the failing reservation is in flight, another allocation takes the budget to the level
+			nested = root.Malloc(16)
+			return true
+		},
+	})
+	root, err := cgoalloc.CreateBudgetAllocator(inner, cgoalloc.BudgetOptions{MaxBytes: 128})
+	require.NoError(t, err)
+
+	fired := 0
+	root.OnPressure(64, func() { fired++ })
+
+	a1 := root.Malloc(48)
+	require.Equal(t, 0, fired)
+
+	_, err = root.TryMalloc(32)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.NotEqual(t, unsafe.Pointer(nil), nested)
+	require.Equal(t, 1, fired)
+	require.Equal(t, 64, root.LiveBytes())
+	require.Equal(t, 2, root.LiveAllocations())
+
+	root.Free(nested)
+	root.Free(a1)
+	require.NoError(t, root.Destroy())
+}
@@ -0,0 +1,164 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+const minCBufferGrowth = 64
+
+// CBuffer is a growable byte buffer which lives in C memory allocated through an Allocator.  It implements io.Writer,
+// io.ByteWriter, io.StringWriter and io.ReaderFrom, so data can be serialized directly into C memory without building
+// a Go slice first.  Pointer and Len can then be used to hand the contents to C.
+//
+// Whenever the buffer needs to grow, it is resized with TryRealloc, so the pointer returned by Pointer is only valid
+// until the next write.  If growing fails, the write returns the allocation error and the buffer keeps its existing
+// contents.  Release must be called to free the buffer's memory once it's no longer needed.
+type CBuffer struct {
+	allocator Allocator
+
+	ptr      unsafe.Pointer
+	length   int
+	capacity int
+}
+
+// CreateCBuffer creates a new, empty CBuffer which allocates its memory from the provided Allocator.  If capacity is
+// greater than 0, that many bytes are allocated up front- if that fails, the buffer starts out empty instead, and the
+// first write will report the error.
+func CreateCBuffer(allocator Allocator, capacity int) *CBuffer {
+	buffer := &CBuffer{allocator: allocator}
+	if capacity > 0 {
+		ptr, err := TryMalloc(allocator, capacity)
+		if err == nil {
+			buffer.ptr = ptr
+			buffer.capacity = capacity
+		}
+	}
+	return buffer
+}
+
+// Pointer returns a pointer to the start of the buffer's contents.  It is nil if the buffer has never allocated.
+func (b *CBuffer) Pointer() unsafe.Pointer { return b.ptr }
+
+// Len returns the number of bytes that have been written to the buffer
+func (b *CBuffer) Len() int { return b.length }
+
+// Cap returns the number of bytes the buffer can hold before it will need to grow
+func (b *CBuffer) Cap() int { return b.capacity }
+
+// Bytes returns a slice which aliases the buffer's contents.  Like Pointer, the slice is only valid until the next
+// write, Reset or Release.
+func (b *CBuffer) Bytes() []byte {
+	return GoBytesView(b.ptr, b.length)
+}
+
+// Grow ensures that at least n more bytes can be written to the buffer without it growing again.  It panics if n is
+// negative or the buffer can't be grown- use TryGrow to handle that instead.
+func (b *CBuffer) Grow(n int) {
+	if err := b.TryGrow(n); err != nil {
+		panic(err)
+	}
+}
+
+// TryGrow is equivalent to Grow, but returns an error instead of panicking if the buffer can't be grown.  The buffer's
+// contents are left untouched when it fails.
+func (b *CBuffer) TryGrow(n int) error {
+	if n < 0 {
+		return fmt.Errorf("cbuffer: attempted to grow by a negative count %d", n)
+	}
+	if n > math.MaxInt-b.length {
+		return fmt.Errorf("cbuffer: growing by %d bytes overflows: %w", n, ErrTooLarge)
+	}
+	if b.length+n <= b.capacity {
+		return nil
+	}
+
+	newCapacity := b.length + n
+	if b.capacity <= math.MaxInt/2 && b.capacity*2 > newCapacity {
+		newCapacity = b.capacity * 2
+	}
+	if newCapacity < minCBufferGrowth {
+		newCapacity = minCBufferGrowth
+	}
+
+	ptr, err := TryRealloc(b.allocator, b.ptr, b.capacity, newCapacity)
+	if err != nil {
+		return fmt.Errorf("cbuffer: %w", err)
+	}
+
+	b.ptr = ptr
+	b.capacity = newCapacity
+	return nil
+}
+
+// unused returns a slice over the buffer's memory past the end of its contents
+func (b *CBuffer) unused() []byte {
+	return unsafe.Slice((*byte)(unsafe.Add(b.ptr, b.length)), b.capacity-b.length)
+}
+
+func (b *CBuffer) Write(p []byte) (int, error) {
+	if err := b.TryGrow(len(p)); err != nil {
+		return 0, err
+	}
+	b.length += copy(b.unused(), p)
+	return len(p), nil
+}
+
+func (b *CBuffer) WriteString(s string) (int, error) {
+	if err := b.TryGrow(len(s)); err != nil {
+		return 0, err
+	}
+	b.length += copy(b.unused(), s)
+	return len(s), nil
+}
+
+func (b *CBuffer) WriteByte(c byte) error {
+	if err := b.TryGrow(1); err != nil {
+		return err
+	}
+	*(*byte)(unsafe.Add(b.ptr, b.length)) = c
+	b.length++
+	return nil
+}
+
+// ReadFrom reads from r directly into the buffer until r returns io.EOF, growing the buffer as needed
+func (b *CBuffer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if b.capacity-b.length < minCBufferGrowth {
+			if err := b.TryGrow(minCBufferGrowth); err != nil {
+				return total, err
+			}
+		}
+
+		n, err := r.Read(b.unused())
+		b.length += n
+		total += int64(n)
+
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Reset empties the buffer without freeing its memory, so that it can be reused
+func (b *CBuffer) Reset() {
+	b.length = 0
+}
+
+// Release frees the buffer's memory.  The buffer is left empty, and can be written to again, in which case it will
+// allocate new memory.
+func (b *CBuffer) Release() {
+	if b.ptr != nil {
+		b.allocator.Free(b.ptr)
+	}
+
+	b.ptr = nil
+	b.length = 0
+	b.capacity = 0
+}
@@ -0,0 +1,79 @@
+package cgoalloc_test
+
+import (
+	"fmt"
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestCBuffer_Grow(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	buffer := cgoalloc.CreateCBuffer(testAlloc, 0)
+
+	_, err := buffer.WriteString("hello")
+	require.NoError(t, err)
+	require.NoError(t, buffer.WriteByte(' '))
+	_, err = fmt.Fprintf(buffer, "%s", strings.Repeat("x", 100))
+	require.NoError(t, err)
+
+	require.Equal(t, 106, buffer.Len())
+	require.Equal(t, "hello "+strings.Repeat("x", 100), string(buffer.Bytes()))
+
+	buffer.Reset()
+	_, err = buffer.Write([]byte{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2}, buffer.Bytes())
+
+	buffer.Release()
+	require.Equal(t, 0, buffer.Cap())
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{64, 128}, allocs)
+	require.Equal(t, []int{64, 128}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestCBuffer_ReadFrom(t *testing.T) {
+	buffer := cgoalloc.CreateCBuffer(createInnerAllocator(t), 16)
+	defer buffer.Release()
+
+	source := strings.Repeat("abcdefgh", 100)
+	n, err := buffer.ReadFrom(strings.NewReader(source))
+	require.NoError(t, err)
+	require.Equal(t, int64(800), n)
+	require.Equal(t, source, string(cgoalloc.GoBytesView(buffer.Pointer(), buffer.Len())))
+}
+
+func TestCBuffer_GrowFailure(t *testing.T) {
+	inner := cgoalloctest.CreateFaultInjectingAllocator(createInnerAllocator(t), cgoalloctest.FaultOptions{FailOnCall: 2})
+	buffer := cgoalloc.CreateCBuffer(inner, 0)
+
+	_, err := buffer.WriteString("hello")
+	require.NoError(t, err)
+
+	// The second allocation fails, so the buffer can't grow, but keeps what it already had
+	_, err = buffer.Write(make([]byte, 100))
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Equal(t, "hello", string(buffer.Bytes()))
+	require.NoError(t, buffer.WriteByte(' '))
+	_, err = buffer.ReadFrom(strings.NewReader(strings.Repeat("x", 100)))
+	require.NoError(t, err)
+	require.Equal(t, "hello "+strings.Repeat("x", 100), string(buffer.Bytes()))
+
+	require.Panics(t, func() {
+		buffer.Grow(-1)
+	})
+
+	buffer.Release()
+	require.NoError(t, inner.Destroy())
+
+	failing := cgoalloctest.CreateFaultInjectingAllocator(createInnerAllocator(t), cgoalloctest.FaultOptions{ByteBudget: 8})
+	buffer = cgoalloc.CreateCBuffer(failing, 16)
+	require.Equal(t, 0, buffer.Cap())
+	_, err = buffer.ReadFrom(strings.NewReader("hello"))
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.NoError(t, failing.Destroy())
+}
@@ -0,0 +1,290 @@
+// Package cgoalloctest provides tools for testing cgoalloc.Allocator implementations, and code that accepts them.
+package cgoalloctest
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"unsafe"
+
+	"github.com/CannibalVox/cgoalloc"
+)
+
+// FreeOrder describes the order in which an Allocator requires its allocations to be freed
+type FreeOrder int
+
+const (
+	// FreeAnyOrder allocations can be freed in any order
+	FreeAnyOrder FreeOrder = iota
+	// FreeLIFO allocations must be freed in the reverse order they were made, as with cgoalloc.StackAllocator
+	FreeLIFO
+	// FreeFIFO allocations must be freed in the order they were made, as with cgoalloc.RingAllocator
+	FreeFIFO
+)
+
+// ConformanceOptions describes the capabilities of the Allocator being tested by RunConformance.  The zero value is
+// suitable for a general-purpose Allocator without leak detection.
+type ConformanceOptions struct {
+	// Seed seeds the random sequence of Malloc and Free calls
+	Seed int64
+	// Operations is the number of Malloc and Free calls made.  Defaults to 2000.
+	Operations int
+	// MinAllocSize and MaxAllocSize bound the sizes passed to Malloc.  MaxAllocSize defaults to 256.
+	MinAllocSize int
+	MaxAllocSize int
+	// MaxLiveBytes, if set, caps the total size of live allocations, for Allocators with a fixed capacity
+	MaxLiveBytes int
+	// Alignment, if set, is checked against every pointer returned by Malloc
+	Alignment uintptr
+	// FreeOrder is the order the Allocator requires allocations to be freed in
+	FreeOrder FreeOrder
+	// DetectsLeaks should be set if Destroy is expected to return an error while allocations are live
+	DetectsLeaks bool
+	// StatelessDestroy should be set if Destroy does nothing, as with cgoalloc.DefaultAllocator, so the Allocator isn't
+	// expected to refuse allocations once it has been destroyed
+	StatelessDestroy bool
+}
+
+type liveAllocation struct {
+	ptr     unsafe.Pointer
+	size    int
+	pattern byte
+}
+
+func (a liveAllocation) bytes() []byte {
+	if a.size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(a.ptr), a.size)
+}
+
+// RunConformance runs a set of subtests against Allocators created by factory, checking the behavior every Allocator
+// is expected to share: pointers are aligned, live allocations never overlap, the contents of an allocation survive
+// neighboring Malloc and Free calls, and Destroy succeeds once everything has been freed.  If opts.DetectsLeaks is
+// set, Destroy is also expected to fail while allocations are live.  If the Allocator is a cgoalloc.TryAllocator, its
+// TryMalloc is expected to return cgoalloc.ErrDestroyed once it has been destroyed, unless opts.StatelessDestroy is
+// set.  If the Allocator is a cgoalloc.Reallocator or cgoalloc.Callocator, Realloc and Calloc are tested as well.
+//
+// factory is called once per subtest, and each Allocator it returns is destroyed by the end of the subtest.
+func RunConformance(t *testing.T, factory func() cgoalloc.Allocator, opts ConformanceOptions) {
+	if opts.Operations == 0 {
+		opts.Operations = 2000
+	}
+	if opts.MaxAllocSize == 0 {
+		opts.MaxAllocSize = 256
+	}
+	if opts.MinAllocSize < 1 {
+		opts.MinAllocSize = 1
+	}
+	if opts.Operations < 0 || opts.MaxAllocSize < 0 || opts.MaxLiveBytes < 0 {
+		t.Fatalf("cgoalloctest: ConformanceOptions must not be negative")
+	}
+	if opts.MinAllocSize > opts.MaxAllocSize {
+		t.Fatalf("cgoalloctest: MinAllocSize (%d) is greater than MaxAllocSize (%d)", opts.MinAllocSize, opts.MaxAllocSize)
+	}
+	if opts.MaxLiveBytes > 0 && opts.MaxLiveBytes < opts.MaxAllocSize {
+		t.Fatalf("cgoalloctest: MaxLiveBytes (%d) is less than MaxAllocSize (%d)", opts.MaxLiveBytes, opts.MaxAllocSize)
+	}
+
+	t.Run("RandomOperations", func(t *testing.T) {
+		testRandomOperations(t, factory(), opts)
+	})
+
+	t.Run("LeakDetection", func(t *testing.T) {
+		if !opts.DetectsLeaks {
+			t.Skip("cgoalloctest: allocator does not detect leaks")
+		}
+		testLeakDetection(t, factory(), opts)
+	})
+
+	t.Run("Destroyed", func(t *testing.T) {
+		if opts.StatelessDestroy {
+			t.Skip("cgoalloctest: allocator has nothing to destroy")
+		}
+		alloc := factory()
+		if _, ok := alloc.(cgoalloc.TryAllocator); !ok {
+			_ = alloc.Destroy()
+			t.Skip("cgoalloctest: allocator does not implement TryAllocator")
+		}
+		testDestroyed(t, alloc, opts)
+	})
+
+	t.Run("Realloc", func(t *testing.T) {
+		alloc := factory()
+		if _, ok := alloc.(cgoalloc.Reallocator); !ok {
+			_ = alloc.Destroy()
+			t.Skip("cgoalloctest: allocator does not implement Reallocator")
+		}
+		testRealloc(t, alloc, opts)
+	})
+
+	t.Run("Calloc", func(t *testing.T) {
+		alloc := factory()
+		if _, ok := alloc.(cgoalloc.Callocator); !ok {
+			_ = alloc.Destroy()
+			t.Skip("cgoalloctest: allocator does not implement Callocator")
+		}
+		testCalloc(t, alloc, opts)
+	})
+}
+
+func checkMalloc(t *testing.T, ptr unsafe.Pointer, size int, live []liveAllocation, opts ConformanceOptions) {
+	t.Helper()
+
+	if ptr == nil {
+		t.Fatalf("cgoalloctest: Malloc(%d) returned nil", size)
+	}
+	if opts.Alignment > 0 && uintptr(ptr)%opts.Alignment != 0 {
+		t.Fatalf("cgoalloctest: Malloc(%d) returned %p, which is not aligned to %d bytes", size, ptr, opts.Alignment)
+	}
+
+	start := uintptr(ptr)
+	end := start + uintptr(size)
+	for _, other := range live {
+		otherStart := uintptr(other.ptr)
+		otherEnd := otherStart + uintptr(other.size)
+		if start < otherEnd && otherStart < end {
+			t.Fatalf("cgoalloctest: Malloc(%d) returned %p, which overlaps the live %d byte allocation at %p", size, ptr, other.size, other.ptr)
+		}
+	}
+}
+
+func checkContents(t *testing.T, allocation liveAllocation) {
+	t.Helper()
+
+	for i, b := range allocation.bytes() {
+		if b != allocation.pattern {
+			t.Fatalf("cgoalloctest: byte %d of the %d byte allocation at %p was overwritten", i, allocation.size, allocation.ptr)
+		}
+	}
+}
+
+func testRandomOperations(t *testing.T, alloc cgoalloc.Allocator, opts ConformanceOptions) {
+	rng := rand.New(rand.NewSource(opts.Seed))
+
+	var live []liveAllocation
+	liveBytes := 0
+	for i := 0; i < opts.Operations; i++ {
+		size := opts.MinAllocSize + rng.Intn(opts.MaxAllocSize-opts.MinAllocSize+1)
+		overCapacity := opts.MaxLiveBytes > 0 && liveBytes+size > opts.MaxLiveBytes
+
+		if len(live) > 0 && (overCapacity || rng.Intn(5) < 2) {
+			index := rng.Intn(len(live))
+			switch opts.FreeOrder {
+			case FreeLIFO:
+				index = len(live) - 1
+			case FreeFIFO:
+				index = 0
+			}
+
+			allocation := live[index]
+			checkContents(t, allocation)
+			alloc.Free(allocation.ptr)
+
+			live = append(live[:index], live[index+1:]...)
+			liveBytes -= allocation.size
+			continue
+		}
+
+		if overCapacity {
+			continue
+		}
+
+		ptr := alloc.Malloc(size)
+		checkMalloc(t, ptr, size, live, opts)
+
+		allocation := liveAllocation{ptr: ptr, size: size, pattern: byte(i)}
+		for j := range allocation.bytes() {
+			allocation.bytes()[j] = allocation.pattern
+		}
+		live = append(live, allocation)
+		liveBytes += size
+	}
+
+	for len(live) > 0 {
+		index := len(live) - 1
+		if opts.FreeOrder == FreeFIFO {
+			index = 0
+		}
+
+		checkContents(t, live[index])
+		alloc.Free(live[index].ptr)
+		live = append(live[:index], live[index+1:]...)
+	}
+
+	if err := alloc.Destroy(); err != nil {
+		t.Fatalf("cgoalloctest: Destroy failed after all allocations were freed: %v", err)
+	}
+}
+
+func testLeakDetection(t *testing.T, alloc cgoalloc.Allocator, opts ConformanceOptions) {
+	ptr := alloc.Malloc(opts.MinAllocSize)
+	if err := alloc.Destroy(); err == nil {
+		t.Fatalf("cgoalloctest: Destroy did not report a leak while an allocation was live")
+	}
+
+	alloc.Free(ptr)
+	if err := alloc.Destroy(); err != nil {
+		t.Fatalf("cgoalloctest: Destroy failed after all allocations were freed: %v", err)
+	}
+}
+
+func testRealloc(t *testing.T, alloc cgoalloc.Allocator, opts ConformanceOptions) {
+	reallocator := alloc.(cgoalloc.Reallocator)
+
+	size := opts.MinAllocSize
+	allocation := liveAllocation{ptr: alloc.Malloc(size), size: size, pattern: 0x5a}
+	checkMalloc(t, allocation.ptr, size, nil, opts)
+	for i := range allocation.bytes() {
+		allocation.bytes()[i] = allocation.pattern
+	}
+
+	newSize := opts.MaxAllocSize
+	newPtr := reallocator.Realloc(allocation.ptr, newSize)
+	checkMalloc(t, newPtr, newSize, nil, opts)
+
+	allocation.ptr = newPtr
+	checkContents(t, allocation)
+	alloc.Free(newPtr)
+
+	if err := alloc.Destroy(); err != nil {
+		t.Fatalf("cgoalloctest: Destroy failed after all allocations were freed: %v", err)
+	}
+}
+
+func testCalloc(t *testing.T, alloc cgoalloc.Allocator, opts ConformanceOptions) {
+	callocator := alloc.(cgoalloc.Callocator)
+
+	// Dirty some memory first, so that a calloc which reuses it has to zero it
+	dirty := liveAllocation{ptr: alloc.Malloc(opts.MaxAllocSize), size: opts.MaxAllocSize}
+	for i := range dirty.bytes() {
+		dirty.bytes()[i] = 0xff
+	}
+	alloc.Free(dirty.ptr)
+
+	count := 4
+	size := opts.MaxAllocSize / count
+	ptr := callocator.Calloc(count, size)
+	checkMalloc(t, ptr, count*size, nil, opts)
+	checkContents(t, liveAllocation{ptr: ptr, size: count * size, pattern: 0})
+	alloc.Free(ptr)
+
+	if err := alloc.Destroy(); err != nil {
+		t.Fatalf("cgoalloctest: Destroy failed after all allocations were freed: %v", err)
+	}
+}
+
+func testDestroyed(t *testing.T, alloc cgoalloc.Allocator, opts ConformanceOptions) {
+	ptr := alloc.Malloc(opts.MinAllocSize)
+	checkMalloc(t, ptr, opts.MinAllocSize, nil, opts)
+	alloc.Free(ptr)
+
+	if err := alloc.Destroy(); err != nil {
+		t.Fatalf("cgoalloctest: Destroy returned an error with no allocations live: %v", err)
+	}
+
+	ptr, err := cgoalloc.TryMalloc(alloc, opts.MinAllocSize)
+	if !errors.Is(err, cgoalloc.ErrDestroyed) {
+		t.Fatalf("cgoalloctest: TryMalloc(%d) after Destroy returned (%p, %v), expected an error wrapping ErrDestroyed", opts.MinAllocSize, ptr, err)
+	}
+}
@@ -0,0 +1,101 @@
+//go:build cgo
+
+package cgoalloctest
+
+import (
+	"testing"
+
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		return &cgoalloc.DefaultAllocator{}
+	}, ConformanceOptions{
+		MaxAllocSize:     4096,
+		StatelessDestroy: true,
+	})
+}
+
+func TestFixedBlockConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateFixedBlockAllocator(&cgoalloc.DefaultAllocator{}, 4096, 64, 8)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		MaxAllocSize: 64,
+		Alignment:    8,
+		DetectsLeaks: true,
+	})
+}
+
+func TestFallbackConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		tier, err := cgoalloc.CreateFixedBlockAllocator(&cgoalloc.DefaultAllocator{}, 4096, 64, 8)
+		require.NoError(t, err)
+		return cgoalloc.CreateFallbackAllocator(tier, &cgoalloc.DefaultAllocator{})
+	}, ConformanceOptions{
+		MaxAllocSize: 256,
+		Alignment:    8,
+	})
+}
+
+func TestArenaConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		return cgoalloc.CreateArenaAllocator(&cgoalloc.DefaultAllocator{})
+	}, ConformanceOptions{
+		Operations:   500,
+		DetectsLeaks: true,
+	})
+}
+
+func TestTLSFConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateTLSFAllocator(&cgoalloc.DefaultAllocator{}, 4096, 16)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		MaxAllocSize: 1024,
+		Alignment:    16,
+		DetectsLeaks: true,
+	})
+}
+
+func TestBuddyConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateBuddyAllocator(&cgoalloc.DefaultAllocator{}, 4, 12)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		MaxAllocSize: 1024,
+		Alignment:    16,
+		DetectsLeaks: true,
+	})
+}
+
+func TestStackConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateStackAllocator(&cgoalloc.DefaultAllocator{}, 16384, 8)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		Alignment:    8,
+		MaxLiveBytes: 8192,
+		FreeOrder:    FreeLIFO,
+		DetectsLeaks: true,
+	})
+}
+
+func TestRingConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateRingAllocator(&cgoalloc.DefaultAllocator{}, 16384, 8, cgoalloc.RingFullFail)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		Alignment:    8,
+		MaxLiveBytes: 4096,
+		FreeOrder:    FreeFIFO,
+		DetectsLeaks: true,
+	})
+}
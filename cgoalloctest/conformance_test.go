@@ -0,0 +1,33 @@
+package cgoalloctest
+
+import (
+	"testing"
+
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoHeapConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		alloc, err := cgoalloc.CreateGoHeapAllocator(4096)
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		Alignment:    16,
+		DetectsLeaks: true,
+	})
+}
+
+func TestBudgetConformance(t *testing.T) {
+	RunConformance(t, func() cgoalloc.Allocator {
+		heap, err := cgoalloc.CreateGoHeapAllocator(4096)
+		require.NoError(t, err)
+		alloc, err := cgoalloc.CreateBudgetAllocator(heap, cgoalloc.BudgetOptions{MaxBytes: 8192})
+		require.NoError(t, err)
+		return alloc
+	}, ConformanceOptions{
+		MaxLiveBytes: 8192,
+		Alignment:    16,
+		DetectsLeaks: true,
+	})
+}
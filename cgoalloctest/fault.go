@@ -0,0 +1,184 @@
+package cgoalloctest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/CannibalVox/cgoalloc"
+)
+
+// ErrInjectedFault is the value a FaultInjectingAllocator panics with when FaultOptions.Panic is set
+var ErrInjectedFault = errors.New("cgoalloctest: injected allocation fault")
+
+// MallocCall describes a call to FaultInjectingAllocator.Malloc, for use by FaultOptions.Predicate
+type MallocCall struct {
+	// Size is the size passed to Malloc
+	Size int
+	// Call is the 1-based index of this call among all Malloc calls made through the allocator
+	Call int
+
+	callers []uintptr
+}
+
+// Frames returns the call stack of this Malloc call, starting with the function which called Malloc
+func (c MallocCall) Frames() *runtime.Frames {
+	return runtime.CallersFrames(c.callers)
+}
+
+// CalledFrom returns true if any function on the call stack of this Malloc call has a fully-qualified name containing
+// function, such as "mypackage.(*Encoder).Encode"
+func (c MallocCall) CalledFrom(function string) bool {
+	frames := c.Frames()
+	for {
+		frame, more := frames.Next()
+		if strings.Contains(frame.Function, function) {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// FaultOptions decides which Malloc calls a FaultInjectingAllocator fails.  A call fails if any of the configured
+// conditions is met.
+type FaultOptions struct {
+	// FailOnCall, if set, fails the Nth call to Malloc, counting from 1
+	FailOnCall int
+	// Probability, if set, fails each call to Malloc with this probability, using a random source seeded with Seed
+	Probability float64
+	Seed        int64
+	// ByteBudget, if set, fails any call to Malloc which would take the allocator's live bytes past this many
+	ByteBudget int
+	// Predicate, if set, fails any call to Malloc for which it returns true.  It is called without any of the
+	// allocator's locks held, so it may use the allocator itself.
+	Predicate func(call MallocCall) bool
+	// Panic causes failed calls to panic with ErrInjectedFault instead of returning nil
+	Panic bool
+}
+
+// FaultInjectingAllocator sits on top of another Allocator and makes some of its Malloc calls fail, so that code
+// which claims to handle allocation failure can be tested against allocators which never fail in practice.  Failed
+// calls return nil, like C.malloc, unless the allocator is configured to panic.  Free ignores nil pointers, again
+// like C.free.
+//
+// FaultInjectingAllocator is safe for concurrent use if the inner Allocator is.
+type FaultInjectingAllocator struct {
+	inner cgoalloc.Allocator
+	opts  FaultOptions
+
+	lock       sync.Mutex
+	rng        *rand.Rand
+	calls      int
+	faults     int
+	liveBytes  int
+	allocSizes map[unsafe.Pointer]int
+}
+
+// CreateFaultInjectingAllocator creates a FaultInjectingAllocator which fails Malloc calls according to opts
+func CreateFaultInjectingAllocator(inner cgoalloc.Allocator, opts FaultOptions) *FaultInjectingAllocator {
+	return &FaultInjectingAllocator{
+		inner: inner,
+		opts:  opts,
+
+		rng:        rand.New(rand.NewSource(opts.Seed)),
+		allocSizes: make(map[unsafe.Pointer]int),
+	}
+}
+
+func (a *FaultInjectingAllocator) shouldFail(size int) bool {
+	a.lock.Lock()
+	a.calls++
+	call := MallocCall{Size: size, Call: a.calls}
+
+	fail := a.opts.FailOnCall > 0 && call.Call == a.opts.FailOnCall
+	if a.opts.Probability > 0 && a.rng.Float64() < a.opts.Probability {
+		fail = true
+	}
+	if a.opts.ByteBudget > 0 && a.liveBytes+size > a.opts.ByteBudget {
+		fail = true
+	}
+	a.lock.Unlock()
+
+	// The predicate is user code, which may well call back into the allocator, so it runs without the lock held
+	if !fail && a.opts.Predicate != nil {
+		// Skip runtime.Callers, shouldFail and Malloc or TryMalloc
+		callers := make([]uintptr, 32)
+		call.callers = callers[:runtime.Callers(3, callers)]
+		fail = a.opts.Predicate(call)
+	}
+
+	if fail {
+		a.lock.Lock()
+		a.faults++
+		a.lock.Unlock()
+	}
+	return fail
+}
+
+func (a *FaultInjectingAllocator) track(ptr unsafe.Pointer, size int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.allocSizes[ptr] = size
+	a.liveBytes += size
+}
+
+func (a *FaultInjectingAllocator) Malloc(size int) unsafe.Pointer {
+	if a.shouldFail(size) {
+		if a.opts.Panic {
+			panic(ErrInjectedFault)
+		}
+		return nil
+	}
+
+	ptr := a.inner.Malloc(size)
+	a.track(ptr, size)
+	return ptr
+}
+
+// TryMalloc fails the same calls Malloc would, returning an error which wraps both ErrInjectedFault and
+// cgoalloc.ErrOutOfMemory.  It never panics, regardless of FaultOptions.Panic.
+func (a *FaultInjectingAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.shouldFail(size) {
+		return nil, fmt.Errorf("%w: %w", ErrInjectedFault, cgoalloc.ErrOutOfMemory)
+	}
+
+	ptr, err := cgoalloc.TryMalloc(a.inner, size)
+	if err != nil {
+		return nil, err
+	}
+
+	a.track(ptr, size)
+	return ptr, nil
+}
+
+func (a *FaultInjectingAllocator) Free(ptr unsafe.Pointer) {
+	if ptr == nil {
+		return
+	}
+
+	a.lock.Lock()
+	a.liveBytes -= a.allocSizes[ptr]
+	delete(a.allocSizes, ptr)
+	a.lock.Unlock()
+
+	a.inner.Free(ptr)
+}
+
+func (a *FaultInjectingAllocator) Destroy() error {
+	return a.inner.Destroy()
+}
+
+// Faults returns the number of Malloc calls which have been failed so far
+func (a *FaultInjectingAllocator) Faults() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.faults
+}
@@ -0,0 +1,120 @@
+package cgoalloctest
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultOnCall(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{FailOnCall: 2})
+
+	first := alloc.Malloc(8)
+	require.NotEqual(t, unsafe.Pointer(nil), first)
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(8))
+	third := alloc.Malloc(8)
+	require.NotEqual(t, unsafe.Pointer(nil), third)
+	require.Equal(t, 1, alloc.Faults())
+
+	alloc.Free(first)
+	alloc.Free(nil)
+	alloc.Free(third)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFaultProbability(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{Probability: 0.5, Seed: 1})
+
+	var ptrs []unsafe.Pointer
+	for i := 0; i < 100; i++ {
+		if ptr := alloc.Malloc(8); ptr != nil {
+			ptrs = append(ptrs, ptr)
+		}
+	}
+	require.Equal(t, 100, len(ptrs)+alloc.Faults())
+	require.Greater(t, alloc.Faults(), 0)
+	require.Greater(t, len(ptrs), 0)
+
+	for _, ptr := range ptrs {
+		alloc.Free(ptr)
+	}
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFaultByteBudget(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{ByteBudget: 64})
+
+	a := alloc.Malloc(48)
+	require.NotEqual(t, unsafe.Pointer(nil), a)
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(32))
+
+	alloc.Free(a)
+	b := alloc.Malloc(32)
+	require.NotEqual(t, unsafe.Pointer(nil), b)
+
+	alloc.Free(b)
+	require.NoError(t, alloc.Destroy())
+}
+
+func mallocFromHelper(alloc cgoalloc.Allocator) unsafe.Pointer {
+	return alloc.Malloc(8)
+}
+
+func TestFaultPredicate(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{
+		Predicate: func(call MallocCall) bool {
+			return call.CalledFrom("cgoalloctest.mallocFromHelper")
+		},
+	})
+
+	ptr := alloc.Malloc(8)
+	require.NotEqual(t, unsafe.Pointer(nil), ptr)
+	require.Equal(t, unsafe.Pointer(nil), mallocFromHelper(alloc))
+
+	alloc.Free(ptr)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFaultPredicateUsesAllocator(t *testing.T) {
+	var alloc *FaultInjectingAllocator
+	alloc = CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{
+		Predicate: func(call MallocCall) bool {
+			return alloc.Faults() == 0 && call.CalledFrom("cgoalloctest.mallocFromHelper")
+		},
+	})
+
+	require.Equal(t, unsafe.Pointer(nil), mallocFromHelper(alloc))
+	ptr := mallocFromHelper(alloc)
+	require.NotEqual(t, unsafe.Pointer(nil), ptr)
+	require.Equal(t, 1, alloc.Faults())
+
+	alloc.Free(ptr)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFaultPanic(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{FailOnCall: 1, Panic: true})
+
+	require.PanicsWithValue(t, ErrInjectedFault, func() {
+		alloc.Malloc(8)
+	})
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFaultTryMalloc(t *testing.T) {
+	alloc := CreateFaultInjectingAllocator(createGoHeap(t), FaultOptions{FailOnCall: 1, Panic: true})
+
+	ptr, err := cgoalloc.CBytesE(alloc, []byte("hello"))
+	require.Equal(t, unsafe.Pointer(nil), ptr)
+	require.True(t, errors.Is(err, cgoalloc.ErrOutOfMemory))
+	require.True(t, errors.Is(err, ErrInjectedFault))
+
+	ptr, err = cgoalloc.CBytesE(alloc, []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), cgoalloc.GoBytesAndFree(alloc, ptr, 5))
+
+	require.NoError(t, alloc.Destroy())
+}
@@ -0,0 +1,184 @@
+package cgoalloctest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/CannibalVox/cgoalloc"
+)
+
+// RecordingAllocator sits on top of another Allocator and records the size of every Malloc and Free made through it.
+// Libraries that accept an Allocator can use it to assert that they free everything they allocate: freeing a pointer
+// the RecordingAllocator didn't hand out, or destroying it while allocations are still live, is reported as an error.
+//
+// If the inner Allocator is a cgoalloc.TierAllocator, the RecordingAllocator can be used as a tier as well, such as
+// in a cgoalloc.FallbackAllocator.  RecordingAllocator is safe for concurrent use if the inner Allocator is.
+type RecordingAllocator struct {
+	inner   cgoalloc.Allocator
+	onError func(err error)
+
+	lock        sync.Mutex
+	allocations []int
+	frees       []int
+	allocSizes  map[unsafe.Pointer]int
+}
+
+// CreateRecordingAllocator creates a RecordingAllocator which reports errors by failing t
+func CreateRecordingAllocator(t testing.TB, inner cgoalloc.Allocator) *RecordingAllocator {
+	return CreateRecordingAllocatorWithHandler(inner, func(err error) {
+		t.Helper()
+		t.Error(err)
+	})
+}
+
+// CreateRecordingAllocatorWithHandler creates a RecordingAllocator which reports errors by passing them to onError, for
+// use outside of go test
+func CreateRecordingAllocatorWithHandler(inner cgoalloc.Allocator, onError func(err error)) *RecordingAllocator {
+	return &RecordingAllocator{
+		inner:   inner,
+		onError: onError,
+
+		allocSizes: make(map[unsafe.Pointer]int),
+	}
+}
+
+func (a *RecordingAllocator) Malloc(size int) unsafe.Pointer {
+	alloc := a.inner.Malloc(size)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.allocations = append(a.allocations, size)
+	a.allocSizes[alloc] = size
+
+	return alloc
+}
+
+// Calloc forwards to cgoalloc.Calloc on the inner Allocator and records an allocation of count*size bytes
+func (a *RecordingAllocator) Calloc(count, size int) unsafe.Pointer {
+	alloc := cgoalloc.Calloc(a.inner, count, size)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.allocations = append(a.allocations, count*size)
+	a.allocSizes[alloc] = count * size
+
+	return alloc
+}
+
+// TryMalloc forwards to cgoalloc.TryMalloc on the inner Allocator, so that wrapping an Allocator doesn't change how its
+// failures are reported.  Only successful allocations are recorded.
+func (a *RecordingAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	alloc, err := cgoalloc.TryMalloc(a.inner, size)
+	if err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.allocations = append(a.allocations, size)
+	a.allocSizes[alloc] = size
+
+	return alloc, nil
+}
+
+func (a *RecordingAllocator) Free(ptr unsafe.Pointer) {
+	a.lock.Lock()
+	size, ok := a.allocSizes[ptr]
+	if ok {
+		delete(a.allocSizes, ptr)
+		a.frees = append(a.frees, size)
+	}
+	a.lock.Unlock()
+
+	if !ok {
+		// Passing an unknown pointer on to the inner allocator would most likely crash, so the error is all we do
+		a.onError(fmt.Errorf("recordingallocator: attempted to free %p, which was not allocated by this allocator or was already freed", ptr))
+		return
+	}
+
+	a.inner.Free(ptr)
+}
+
+func (a *RecordingAllocator) tier() (cgoalloc.TierAllocator, bool) {
+	tier, ok := a.inner.(cgoalloc.TierAllocator)
+	if !ok {
+		a.onError(fmt.Errorf("recordingallocator: used as a TierAllocator but the inner allocator %T isn't one", a.inner))
+	}
+	return tier, ok
+}
+
+// MaxAllocSize forwards to the inner Allocator, which must be a cgoalloc.TierAllocator
+func (a *RecordingAllocator) MaxAllocSize() int {
+	tier, ok := a.tier()
+	if !ok {
+		return 0
+	}
+	return tier.MaxAllocSize()
+}
+
+// Owns forwards to the inner Allocator, which must be a cgoalloc.TierAllocator
+func (a *RecordingAllocator) Owns(ptr unsafe.Pointer) bool {
+	tier, ok := a.tier()
+	if !ok {
+		return false
+	}
+	return tier.Owns(ptr)
+}
+
+// TryFree forwards to the inner Allocator, which must be a cgoalloc.TierAllocator, and records the free if it succeeds.
+// Pointers the RecordingAllocator didn't hand out are left for the caller to free elsewhere, rather than reported.
+func (a *RecordingAllocator) TryFree(ptr unsafe.Pointer) bool {
+	tier, ok := a.tier()
+	if !ok {
+		return false
+	}
+
+	a.lock.Lock()
+	size, ok := a.allocSizes[ptr]
+	if ok {
+		delete(a.allocSizes, ptr)
+		a.frees = append(a.frees, size)
+	}
+	a.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+	tier.Free(ptr)
+	return true
+}
+
+// Destroy reports an error if any allocations are still live, then destroys the inner Allocator
+func (a *RecordingAllocator) Destroy() error {
+	a.lock.Lock()
+	live := len(a.allocSizes)
+	a.lock.Unlock()
+
+	if live > 0 {
+		a.onError(fmt.Errorf("recordingallocator: attempted to Destroy with %d allocations still live", live))
+	}
+	return a.inner.Destroy()
+}
+
+// Record returns the sizes of every Malloc and Free made through this allocator, in the order they were made
+func (a *RecordingAllocator) Record() (allocs []int, frees []int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	allocs = append([]int(nil), a.allocations...)
+	frees = append([]int(nil), a.frees...)
+	return allocs, frees
+}
+
+// LiveAllocations returns the number of allocations which have not yet been freed
+func (a *RecordingAllocator) LiveAllocations() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return len(a.allocSizes)
+}
@@ -0,0 +1,91 @@
+package cgoalloctest
+
+import (
+	"testing"
+
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/stretchr/testify/require"
+)
+
+func createGoHeap(t *testing.T) cgoalloc.Allocator {
+	alloc, err := cgoalloc.CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+	return alloc
+}
+
+func TestRecordingRecord(t *testing.T) {
+	alloc := CreateRecordingAllocator(t, createGoHeap(t))
+
+	a := alloc.Malloc(8)
+	b := alloc.Malloc(16)
+	require.Equal(t, 2, alloc.LiveAllocations())
+
+	alloc.Free(b)
+	alloc.Free(a)
+	require.Equal(t, 0, alloc.LiveAllocations())
+
+	allocs, frees := alloc.Record()
+	require.Equal(t, []int{8, 16}, allocs)
+	require.Equal(t, []int{16, 8}, frees)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestRecordingErrors(t *testing.T) {
+	var errs []error
+	alloc := CreateRecordingAllocatorWithHandler(createGoHeap(t), func(err error) {
+		errs = append(errs, err)
+	})
+
+	ptr := alloc.Malloc(8)
+	alloc.Free(ptr)
+	alloc.Free(ptr)
+	require.Len(t, errs, 1)
+
+	_ = alloc.Malloc(8)
+	_ = alloc.Destroy()
+	require.Len(t, errs, 2)
+
+	require.False(t, alloc.Owns(ptr))
+	require.Len(t, errs, 3)
+}
+
+func TestRecordingTier(t *testing.T) {
+	fba, err := cgoalloc.CreateFixedBlockAllocator(createGoHeap(t), 1024, 64, 8)
+	require.NoError(t, err)
+
+	tier := CreateRecordingAllocator(t, fba)
+	fallback := CreateRecordingAllocator(t, createGoHeap(t))
+	alloc := cgoalloc.CreateFallbackAllocator(tier, fallback)
+
+	small := alloc.Malloc(32)
+	large := alloc.Malloc(128)
+	alloc.Free(small)
+	alloc.Free(large)
+
+	tierAllocs, tierFrees := tier.Record()
+	require.Equal(t, []int{32}, tierAllocs)
+	require.Equal(t, []int{32}, tierFrees)
+
+	fallbackAllocs, fallbackFrees := fallback.Record()
+	require.Equal(t, []int{128}, fallbackAllocs)
+	require.Equal(t, []int{128}, fallbackFrees)
+
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestRecordingTryMalloc(t *testing.T) {
+	fba, err := cgoalloc.CreateFixedBlockAllocator(createGoHeap(t), 1024, 64, 8)
+	require.NoError(t, err)
+	alloc := CreateRecordingAllocator(t, fba)
+
+	ptr, err := cgoalloc.TryMalloc(alloc, 32)
+	require.NoError(t, err)
+	_, err = cgoalloc.TryMalloc(alloc, 128)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+	alloc.Free(ptr)
+
+	allocs, frees := alloc.Record()
+	require.Equal(t, []int{32}, allocs)
+	require.Equal(t, []int{32}, frees)
+	require.NoError(t, alloc.Destroy())
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const marshalDirective = "//cgoalloc:marshal"
+
+type fieldKind int
+
+const (
+	// fieldValue fields have the same layout in Go and C, and are copied directly
+	fieldValue fieldKind = iota
+	// fieldStruct fields are annotated structs laid out inline
+	fieldStruct
+	// fieldStructArray fields are fixed-size arrays of annotated structs
+	fieldStructArray
+	fieldCString
+	fieldPointer
+	fieldSlice
+)
+
+type structField struct {
+	name     string
+	kind     fieldKind
+	typeExpr string
+
+	// elemType is the element type of pointers, slices and arrays, and elemStruct is true if it's an annotated struct
+	elemType   string
+	elemStruct bool
+	arrayLen   string
+
+	// lenField is the name of the field that receives the length of a slice, and lenOf is the reverse
+	lenField string
+	lenOf    string
+}
+
+type structType struct {
+	name   string
+	fields []*structField
+}
+
+func mirrorName(typeName string) string {
+	return "cgoalloc" + strings.ToUpper(typeName[:1]) + typeName[1:]
+}
+
+// findAnnotatedStructs returns every struct type in files whose declaration carries the marshal directive
+func findAnnotatedStructs(files []*ast.File) map[string]*ast.StructType {
+	structs := make(map[string]*ast.StructType)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				structType, isStruct := typeSpec.Type.(*ast.StructType)
+				if !isStruct || !(hasDirective(genDecl.Doc) || hasDirective(typeSpec.Doc)) {
+					continue
+				}
+				structs[typeSpec.Name.Name] = structType
+			}
+		}
+	}
+	return structs
+}
+
+// findTypeDecls returns the type expression of every named type declared in files
+func findTypeDecls(files []*ast.File) map[string]ast.Expr {
+	decls := make(map[string]ast.Expr)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				decls[typeSpec.Name.Name] = typeSpec.Type
+			}
+		}
+	}
+	return decls
+}
+
+// goPointerIn returns the part of expr that holds a Go pointer, if any.  Named types declared in the scanned package
+// are followed.  Types declared in other packages can't be seen from here, so they're returned too, with foreign set-
+// except for unsafe.Pointer, which is allowed, since it's how Go code usually holds on to C pointers.
+func goPointerIn(fset *token.FileSet, expr ast.Expr, decls map[string]ast.Expr, seen map[string]bool) (found string, foreign bool, ok bool) {
+	switch expr := expr.(type) {
+	case *ast.StarExpr, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType:
+		return exprString(fset, expr), false, true
+	case *ast.ArrayType:
+		if expr.Len == nil {
+			return exprString(fset, expr), false, true
+		}
+		return goPointerIn(fset, expr.Elt, decls, seen)
+	case *ast.ParenExpr:
+		return goPointerIn(fset, expr.X, decls, seen)
+	case *ast.StructType:
+		for _, field := range expr.Fields.List {
+			if found, foreign, ok := goPointerIn(fset, field.Type, decls, seen); ok {
+				return found, foreign, true
+			}
+		}
+	case *ast.SelectorExpr:
+		if pkg, isIdent := expr.X.(*ast.Ident); isIdent && pkg.Name == "unsafe" && expr.Sel.Name == "Pointer" {
+			return "", false, false
+		}
+		return exprString(fset, expr), true, true
+	case *ast.Ident:
+		switch expr.Name {
+		case "string", "any", "error":
+			return expr.Name, false, true
+		}
+		if decl, ok := decls[expr.Name]; ok && !seen[expr.Name] {
+			seen[expr.Name] = true
+			return goPointerIn(fset, decl, decls, seen)
+		}
+	}
+	return "", false, false
+}
+
+var integerTypes = map[string]bool{
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"byte": true, "rune": true,
+}
+
+// isIntegerType returns true if expr is one of Go's integer types, or a named type declared in the scanned package
+// whose underlying type is one
+func isIntegerType(expr ast.Expr, decls map[string]ast.Expr) bool {
+	seen := make(map[string]bool)
+	for {
+		switch typed := expr.(type) {
+		case *ast.ParenExpr:
+			expr = typed.X
+			continue
+		case *ast.Ident:
+			if decl, ok := decls[typed.Name]; ok && !seen[typed.Name] {
+				seen[typed.Name] = true
+				expr = decl
+				continue
+			}
+			return integerTypes[typed.Name]
+		}
+		return false
+	}
+}
+
+func hasDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+
+	for _, comment := range doc.List {
+		if strings.TrimSpace(comment.Text) == marshalDirective {
+			return true
+		}
+	}
+	return false
+}
+
+func exprString(fset *token.FileSet, expr ast.Expr) string {
+	var buffer bytes.Buffer
+	_ = printer.Fprint(&buffer, fset, expr)
+	return buffer.String()
+}
+
+func isAnnotated(expr ast.Expr, annotated map[string]*ast.StructType) (string, bool) {
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	_, ok = annotated[ident.Name]
+	return ident.Name, ok
+}
+
+func parseStruct(fset *token.FileSet, name string, astStruct *ast.StructType, annotated map[string]*ast.StructType, decls map[string]ast.Expr) (*structType, error) {
+	parsed := &structType{name: name}
+	byName := make(map[string]*structField)
+	positions := make(map[string]token.Position)
+	types := make(map[string]ast.Expr)
+
+	for _, astField := range astStruct.Fields.List {
+		if len(astField.Names) == 0 {
+			return nil, fmt.Errorf("%s: %s: embedded fields are not supported", fset.Position(astField.Pos()), name)
+		}
+
+		tag := ""
+		hasTag := false
+		if astField.Tag != nil {
+			unquoted, err := strconv.Unquote(astField.Tag.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: malformed tag %s", fset.Position(astField.Tag.Pos()), name, astField.Tag.Value)
+			}
+			tag, hasTag = reflect.StructTag(unquoted).Lookup("c")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		for _, fieldName := range astField.Names {
+			field, err := parseField(fset, name, fieldName.Name, astField.Type, tag, hasTag, annotated, decls)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fset.Position(fieldName.Pos()), err)
+			}
+			parsed.fields = append(parsed.fields, field)
+			byName[field.name] = field
+			positions[field.name] = fset.Position(fieldName.Pos())
+			types[field.name] = astField.Type
+		}
+	}
+
+	for _, field := range parsed.fields {
+		if field.kind != fieldSlice {
+			continue
+		}
+
+		lenField, ok := byName[field.lenField]
+		if !ok || lenField.kind != fieldValue || !isIntegerType(types[lenField.name], decls) {
+			return nil, fmt.Errorf("%s: %s.%s: length field %s must be an integer field of the same struct", positions[field.name], name, field.name, field.lenField)
+		}
+		lenField.lenOf = field.name
+	}
+
+	return parsed, nil
+}
+
+func parseField(fset *token.FileSet, structName, name string, typeExpr ast.Expr, tag string, hasTag bool, annotated map[string]*ast.StructType, decls map[string]ast.Expr) (*structField, error) {
+	field := &structField{name: name, typeExpr: exprString(fset, typeExpr)}
+
+	// checkNoGoPointers rejects types that would put Go pointers into C memory, where the GC can't see them
+	checkNoGoPointers := func(expr ast.Expr, what string) error {
+		found, foreign, ok := goPointerIn(fset, expr, decls, make(map[string]bool))
+		if ok && foreign {
+			return fmt.Errorf("%s.%s: %s %s uses %s, which is declared in another package, so it can't be checked for Go pointers", structName, name, what, exprString(fset, expr), found)
+		} else if ok {
+			return fmt.Errorf("%s.%s: %s %s contains a Go pointer (%s), which cannot be stored in C memory", structName, name, what, exprString(fset, expr), found)
+		}
+		return nil
+	}
+
+	if !hasTag {
+		switch expr := typeExpr.(type) {
+		case *ast.StarExpr, *ast.ArrayType:
+			arrayType, isArray := expr.(*ast.ArrayType)
+			if !isArray || arrayType.Len == nil {
+				return nil, fmt.Errorf("%s.%s: %s fields need a c tag to be marshalled", structName, name, field.typeExpr)
+			}
+
+			field.elemType = exprString(fset, arrayType.Elt)
+			field.arrayLen = exprString(fset, arrayType.Len)
+			if _, ok := isAnnotated(arrayType.Elt, annotated); ok {
+				field.kind = fieldStructArray
+			} else if err := checkNoGoPointers(arrayType.Elt, "element type"); err != nil {
+				return nil, err
+			}
+		case *ast.Ident:
+			if expr.Name == "string" {
+				return nil, fmt.Errorf("%s.%s: string fields need a c tag to be marshalled", structName, name)
+			}
+			if _, ok := isAnnotated(expr, annotated); ok {
+				field.kind = fieldStruct
+			} else if err := checkNoGoPointers(expr, "type"); err != nil {
+				return nil, err
+			}
+		case *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType:
+			return nil, fmt.Errorf("%s.%s: %s fields cannot be marshalled", structName, name, field.typeExpr)
+		default:
+			if err := checkNoGoPointers(expr, "type"); err != nil {
+				return nil, err
+			}
+		}
+		return field, nil
+	}
+
+	options := strings.Split(tag, ",")
+	for _, option := range options[1:] {
+		if !strings.HasPrefix(option, "len=") || options[0] != "array" {
+			return nil, fmt.Errorf("%s.%s: unknown c tag option %q", structName, name, option)
+		}
+		field.lenField = strings.TrimPrefix(option, "len=")
+	}
+
+	switch options[0] {
+	case "cstring":
+		if ident, ok := typeExpr.(*ast.Ident); !ok || ident.Name != "string" {
+			return nil, fmt.Errorf("%s.%s: tagged cstring, but is a %s", structName, name, field.typeExpr)
+		}
+		field.kind = fieldCString
+	case "ptr":
+		starExpr, ok := typeExpr.(*ast.StarExpr)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: tagged ptr, but is a %s", structName, name, field.typeExpr)
+		}
+		field.kind = fieldPointer
+		field.elemType = exprString(fset, starExpr.X)
+		_, field.elemStruct = isAnnotated(starExpr.X, annotated)
+		if !field.elemStruct {
+			if err := checkNoGoPointers(starExpr.X, "element type"); err != nil {
+				return nil, err
+			}
+		}
+	case "array":
+		arrayType, ok := typeExpr.(*ast.ArrayType)
+		if !ok || arrayType.Len != nil {
+			return nil, fmt.Errorf("%s.%s: tagged array, but is a %s", structName, name, field.typeExpr)
+		}
+		if field.lenField == "" {
+			return nil, fmt.Errorf("%s.%s: array fields need a len= option so they can be unmarshalled", structName, name)
+		}
+		field.kind = fieldSlice
+		field.elemType = exprString(fset, arrayType.Elt)
+		_, field.elemStruct = isAnnotated(arrayType.Elt, annotated)
+		if !field.elemStruct {
+			if err := checkNoGoPointers(arrayType.Elt, "element type"); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s.%s: unknown c tag %q", structName, name, tag)
+	}
+
+	return field, nil
+}
+
+// mirrorType returns the type the field has in the generated C-layout struct
+func (f *structField) mirrorType() string {
+	switch f.kind {
+	case fieldStruct:
+		return mirrorName(f.typeExpr)
+	case fieldStructArray:
+		return "[" + f.arrayLen + "]" + mirrorName(f.elemType)
+	case fieldCString, fieldPointer, fieldSlice:
+		return "unsafe.Pointer"
+	default:
+		return f.typeExpr
+	}
+}
+
+// writeMarshal writes the statements that copy the field into dst.  Allocation failures are returned straight away-
+// MarshalC frees whatever was allocated before them.
+func (f *structField) writeMarshal(out *bytes.Buffer) {
+	const returnErr = "if err != nil {\n\t\treturn err\n\t}\n"
+
+	switch f.kind {
+	case fieldValue:
+		if f.lenOf != "" {
+			fmt.Fprintf(out, "\tdst.%s = %s(len(v.%s))\n", f.name, f.typeExpr, f.lenOf)
+		} else {
+			fmt.Fprintf(out, "\tdst.%s = v.%s\n", f.name, f.name)
+		}
+	case fieldStruct:
+		fmt.Fprintf(out, "\tif err := v.%s.marshalCInto(a, &dst.%s); err != nil {\n\t\treturn err\n\t}\n", f.name, f.name)
+	case fieldStructArray:
+		fmt.Fprintf(out, "\tfor i := range v.%s {\n\t\tif err := v.%s[i].marshalCInto(a, &dst.%s[i]); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n", f.name, f.name, f.name)
+	case fieldCString:
+		fmt.Fprintf(out, "\tdst.%s, err = cgoalloc.CStringPointerE(a, v.%s)\n\t"+returnErr, f.name, f.name)
+	case fieldPointer:
+		fmt.Fprintf(out, "\tif v.%s != nil {\n", f.name)
+		if f.elemStruct {
+			fmt.Fprintf(out, "\t\telem, err := cgoalloc.NewE[%s](a)\n\t\tif err == nil {\n\t\t\terr = v.%s.marshalCInto(a, elem)\n\t\t}\n", mirrorName(f.elemType), f.name)
+		} else {
+			fmt.Fprintf(out, "\t\telem, err := cgoalloc.NewE[%s](a)\n", f.elemType)
+		}
+		fmt.Fprintf(out, "\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n")
+		if !f.elemStruct {
+			fmt.Fprintf(out, "\t\t*elem = *v.%s\n", f.name)
+		}
+		fmt.Fprintf(out, "\t\tdst.%s = unsafe.Pointer(elem)\n\t}\n", f.name)
+	case fieldSlice:
+		fmt.Fprintf(out, "\tif len(v.%s) > 0 {\n", f.name)
+		if f.elemStruct {
+			fmt.Fprintf(out, "\t\telems, err := cgoalloc.MakeSliceE[%s](a, len(v.%s))\n", mirrorName(f.elemType), f.name)
+			fmt.Fprintf(out, "\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n")
+			fmt.Fprintf(out, "\t\tfor i := range v.%s {\n\t\t\tif err := v.%s[i].marshalCInto(a, &elems[i]); err != nil {\n\t\t\t\treturn err\n\t\t\t}\n\t\t}\n", f.name, f.name)
+			fmt.Fprintf(out, "\t\tdst.%s = unsafe.Pointer(&elems[0])\n", f.name)
+		} else {
+			fmt.Fprintf(out, "\t\tptr, err := cgoalloc.CopySliceE(a, v.%s)\n", f.name)
+			fmt.Fprintf(out, "\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n")
+			fmt.Fprintf(out, "\t\tdst.%s = ptr\n", f.name)
+		}
+		fmt.Fprintf(out, "\t}\n")
+	}
+}
+
+func (f *structField) writeUnmarshal(out *bytes.Buffer) {
+	switch f.kind {
+	case fieldValue:
+		fmt.Fprintf(out, "\tv.%s = src.%s\n", f.name, f.name)
+	case fieldStruct:
+		fmt.Fprintf(out, "\tv.%s.unmarshalCFrom(&src.%s)\n", f.name, f.name)
+	case fieldStructArray:
+		fmt.Fprintf(out, "\tfor i := range v.%s {\n\t\tv.%s[i].unmarshalCFrom(&src.%s[i])\n\t}\n", f.name, f.name, f.name)
+	case fieldCString:
+		fmt.Fprintf(out, "\tv.%s = cgoallocGoString(src.%s)\n", f.name, f.name)
+	case fieldPointer:
+		fmt.Fprintf(out, "\tv.%s = nil\n\tif src.%s != nil {\n", f.name, f.name)
+		if f.elemStruct {
+			fmt.Fprintf(out, "\t\tv.%s = new(%s)\n\t\tv.%s.UnmarshalC(src.%s)\n", f.name, f.elemType, f.name, f.name)
+		} else {
+			fmt.Fprintf(out, "\t\telem := *(*%s)(src.%s)\n\t\tv.%s = &elem\n", f.elemType, f.name, f.name)
+		}
+		fmt.Fprintf(out, "\t}\n")
+	case fieldSlice:
+		fmt.Fprintf(out, "\tv.%s = nil\n\tif src.%s != nil && src.%s > 0 {\n", f.name, f.name, f.lenField)
+		if f.elemStruct {
+			fmt.Fprintf(out, "\t\tv.%s = make([]%s, src.%s)\n", f.name, f.elemType, f.lenField)
+			fmt.Fprintf(out, "\t\tfor i, elem := range unsafe.Slice((*%s)(src.%s), src.%s) {\n", mirrorName(f.elemType), f.name, f.lenField)
+			fmt.Fprintf(out, "\t\t\tv.%s[i].unmarshalCFrom(&elem)\n\t\t}\n", f.name)
+		} else {
+			fmt.Fprintf(out, "\t\tv.%s = append([]%s(nil), unsafe.Slice((*%s)(src.%s), src.%s)...)\n", f.name, f.elemType, f.elemType, f.name, f.lenField)
+		}
+		fmt.Fprintf(out, "\t}\n")
+	}
+}
+
+// generate produces the source of a file in package pkgName containing C-layout mirror structs and MarshalC/UnmarshalC
+// methods for every annotated struct in files
+func generate(fset *token.FileSet, pkgName string, files []*ast.File) ([]byte, error) {
+	annotated := findAnnotatedStructs(files)
+	decls := findTypeDecls(files)
+	if len(annotated) == 0 {
+		return nil, errors.New("no struct types are annotated with " + marshalDirective)
+	}
+
+	names := make([]string, 0, len(annotated))
+	for name := range annotated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by cgoalloc-gen. DO NOT EDIT.\n\npackage %s\n\n", pkgName)
+	fmt.Fprintf(&out, "import (\n\t\"unsafe\"\n\n\t\"github.com/CannibalVox/cgoalloc\"\n)\n")
+
+	usesGoString := false
+	for _, name := range names {
+		parsed, err := parseStruct(fset, name, annotated[name], annotated, decls)
+		if err != nil {
+			return nil, err
+		}
+
+		mirror := mirrorName(name)
+		fmt.Fprintf(&out, "\n// %s is the C layout of %s\ntype %s struct {\n", mirror, name, mirror)
+		hasCString := false
+		for _, field := range parsed.fields {
+			fmt.Fprintf(&out, "\t%s %s\n", field.name, field.mirrorType())
+			hasCString = hasCString || field.kind == fieldCString
+		}
+		usesGoString = usesGoString || hasCString
+		fmt.Fprintf(&out, "}\n")
+
+		fmt.Fprintf(&out, "\n// MarshalC writes a C-layout copy of v into memory allocated from a, and returns a pointer to it.  Strings, pointers\n")
+		fmt.Fprintf(&out, "// and slices are allocated from a as well, so an ArenaAllocator can be used to free the whole graph at once.  If an\n")
+		fmt.Fprintf(&out, "// allocation fails, everything allocated so far is freed and the error is returned.\n")
+		fmt.Fprintf(&out, "func (v *%s) MarshalC(a cgoalloc.Allocator) (unsafe.Pointer, error) {\n", name)
+		fmt.Fprintf(&out, "\ttracked := cgoalloc.CreateArenaAllocator(a)\n\tdst, err := cgoalloc.NewE[%s](tracked)\n", mirror)
+		fmt.Fprintf(&out, "\tif err == nil {\n\t\terr = v.marshalCInto(tracked, dst)\n\t}\n")
+		fmt.Fprintf(&out, "\tif err != nil {\n\t\ttracked.FreeAll()\n\t\treturn nil, err\n\t}\n\treturn unsafe.Pointer(dst), nil\n}\n")
+
+		fmt.Fprintf(&out, "\nfunc (v *%s) marshalCInto(a cgoalloc.Allocator, dst *%s) error {\n", name, mirror)
+		if hasCString {
+			fmt.Fprintf(&out, "\tvar err error\n")
+		}
+		for _, field := range parsed.fields {
+			field.writeMarshal(&out)
+		}
+		fmt.Fprintf(&out, "\treturn nil\n}\n")
+
+		fmt.Fprintf(&out, "\n// UnmarshalC reads the C-layout struct at ptr into v.  ptr is not freed.\n")
+		fmt.Fprintf(&out, "func (v *%s) UnmarshalC(ptr unsafe.Pointer) {\n\tv.unmarshalCFrom((*%s)(ptr))\n}\n", name, mirror)
+
+		fmt.Fprintf(&out, "\nfunc (v *%s) unmarshalCFrom(src *%s) {\n", name, mirror)
+		for _, field := range parsed.fields {
+			field.writeUnmarshal(&out)
+		}
+		fmt.Fprintf(&out, "}\n")
+	}
+
+	if usesGoString {
+		// Rather than cgoalloc.GoString, so that the generated code doesn't require cgo
+		fmt.Fprintf(&out, `
+func cgoallocGoString(ptr unsafe.Pointer) string {
+	if ptr == nil {
+		return ""
+	}
+
+	length := 0
+	for *(*byte)(unsafe.Add(ptr, length)) != 0 {
+		length++
+	}
+	return string(cgoalloc.GoBytesView(ptr, length))
+}
+`)
+	}
+
+	return format.Source(out.Bytes())
+}
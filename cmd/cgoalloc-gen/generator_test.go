@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func generateFromSource(t *testing.T, src string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "types.go", src, parser.ParseComments)
+	require.NoError(t, err)
+
+	out, err := generate(fset, file.Name.Name, []*ast.File{file})
+	return string(out), err
+}
+
+func TestGenerate_Marshal(t *testing.T) {
+	out, err := generateFromSource(t, `package types
+
+//cgoalloc:marshal
+type Child struct {
+	Value int32
+}
+
+//cgoalloc:marshal
+type Parent struct {
+	Name     string  `+"`c:\"cstring\"`"+`
+	Children []Child `+"`c:\"array,len=Count\"`"+`
+	Extra    *Child  `+"`c:\"ptr\"`"+`
+	Count    uint32
+	Inline   Child
+	Cache    map[string]int `+"`c:\"-\"`"+`
+}
+
+type Ignored struct {
+	Name string
+}
+`)
+	require.NoError(t, err)
+
+	require.Contains(t, out, "type cgoallocParent struct {\n\tName     unsafe.Pointer\n\tChildren unsafe.Pointer\n\tExtra    unsafe.Pointer\n\tCount    uint32\n\tInline   cgoallocChild\n}")
+	require.Contains(t, out, "func (v *Parent) MarshalC(a cgoalloc.Allocator) (unsafe.Pointer, error) {")
+	require.Contains(t, out, "func (v *Child) UnmarshalC(ptr unsafe.Pointer) {")
+	require.Contains(t, out, "\tdst.Count = uint32(len(v.Children))\n")
+	require.Contains(t, out, "\tdst.Name, err = cgoalloc.CStringPointerE(a, v.Name)\n")
+	require.Contains(t, out, "\t\telem, err := cgoalloc.NewE[cgoallocChild](a)\n")
+	require.NotContains(t, out, "Malloc")
+	require.Contains(t, out, "func cgoallocGoString(ptr unsafe.Pointer) string {")
+	require.NotContains(t, out, "Ignored")
+	require.NotContains(t, out, "Cache")
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	_, err := generateFromSource(t, "package types\n\ntype Plain struct{}\n")
+	require.EqualError(t, err, "no struct types are annotated with //cgoalloc:marshal")
+
+	_, err = generateFromSource(t, "package types\n\n//cgoalloc:marshal\ntype Bad struct {\n\tName string\n}\n")
+	require.EqualError(t, err, "types.go:5:2: Bad.Name: string fields need a c tag to be marshalled")
+
+	_, err = generateFromSource(t, "package types\n\n//cgoalloc:marshal\ntype Bad struct {\n\tValues []int32 `c:\"array\"`\n}\n")
+	require.EqualError(t, err, "types.go:5:2: Bad.Values: array fields need a len= option so they can be unmarshalled")
+
+	_, err = generateFromSource(t, "package types\n\n//cgoalloc:marshal\ntype Bad struct {\n\tValues []int32 `c:\"array,len=Count\"`\n\tCount float32\n}\n")
+	require.EqualError(t, err, "types.go:5:2: Bad.Values: length field Count must be an integer field of the same struct")
+
+	out, err := generateFromSource(t, "package types\n\ntype Length uint16\n\n//cgoalloc:marshal\ntype Good struct {\n\tValues []int32 `c:\"array,len=Count\"`\n\tCount Length\n}\n")
+	require.NoError(t, err)
+	require.Contains(t, out, "\tdst.Count = Length(len(v.Values))\n")
+}
+
+func TestGenerate_GoPointers(t *testing.T) {
+	_, err := generateFromSource(t, "package types\n\n//cgoalloc:marshal\ntype Bad struct {\n\tNames []string `c:\"array,len=Count\"`\n\tCount int32\n}\n")
+	require.EqualError(t, err, "types.go:5:2: Bad.Names: element type string contains a Go pointer (string), which cannot be stored in C memory")
+
+	_, err = generateFromSource(t, "package types\n\n//cgoalloc:marshal\ntype Bad struct {\n\tValue **int32 `c:\"ptr\"`\n}\n")
+	require.EqualError(t, err, "types.go:5:2: Bad.Value: element type *int32 contains a Go pointer (*int32), which cannot be stored in C memory")
+
+	_, err = generateFromSource(t, "package types\n\ntype Inner struct {\n\tData []byte\n}\n\n//cgoalloc:marshal\ntype Bad struct {\n\tInners [2]Inner\n}\n")
+	require.EqualError(t, err, "types.go:9:2: Bad.Inners: element type Inner contains a Go pointer ([]byte), which cannot be stored in C memory")
+
+	_, err = generateFromSource(t, "package types\n\nimport \"time\"\n\n//cgoalloc:marshal\ntype Bad struct {\n\tWhen time.Time\n}\n")
+	require.EqualError(t, err, "types.go:7:2: Bad.When: type time.Time uses time.Time, which is declared in another package, so it can't be checked for Go pointers")
+
+	_, err = generateFromSource(t, "package types\n\nimport \"time\"\n\n//cgoalloc:marshal\ntype Bad struct {\n\tWhen *time.Time `c:\"ptr\"`\n}\n")
+	require.EqualError(t, err, "types.go:7:2: Bad.When: element type time.Time uses time.Time, which is declared in another package, so it can't be checked for Go pointers")
+
+	_, err = generateFromSource(t, "package types\n\nimport \"unsafe\"\n\n//cgoalloc:marshal\ntype Good struct {\n\tHandle unsafe.Pointer\n}\n")
+	require.NoError(t, err)
+}
+
+func TestRun_BuildConstraints(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "types.go"), []byte("package types\n\n//cgoalloc:marshal\ntype Good struct {\n\tValue int32\n}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "excluded.go"), []byte("//go:build ignore\n\npackage types\n\n//cgoalloc:marshal\ntype Excluded struct {\n\tName string\n}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "types_test.go"), []byte("package types_test\n"), 0644))
+
+	output := filepath.Join(dir, defaultOutput)
+	require.NoError(t, run(dir, output))
+
+	generated, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Contains(t, string(generated), "type cgoallocGood struct")
+	require.NotContains(t, string(generated), "Excluded")
+
+	// The previous output is skipped when generating again, rather than being scanned along with the package
+	require.NoError(t, run(dir, output))
+}
+
+// internal/example holds generated code which is compiled and round-tripped by its own tests, so it must be kept in
+// step with the generator
+func TestGenerate_ExampleUpToDate(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	output := filepath.Join(t.TempDir(), defaultOutput)
+	require.NoError(t, run(dir, output))
+
+	generated, err := os.ReadFile(output)
+	require.NoError(t, err)
+	committed, err := os.ReadFile(filepath.Join(dir, defaultOutput))
+	require.NoError(t, err)
+	require.Equal(t, string(committed), string(generated), "internal/example is stale- run go generate ./cmd/cgoalloc-gen/internal/example")
+}
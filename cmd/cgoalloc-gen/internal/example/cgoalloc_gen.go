@@ -0,0 +1,180 @@
+// Code generated by cgoalloc-gen. DO NOT EDIT.
+
+package example
+
+import (
+	"unsafe"
+
+	"github.com/CannibalVox/cgoalloc"
+)
+
+// cgoallocPoint is the C layout of Point
+type cgoallocPoint struct {
+	X float32
+	Y float32
+}
+
+// MarshalC writes a C-layout copy of v into memory allocated from a, and returns a pointer to it.  Strings, pointers
+// and slices are allocated from a as well, so an ArenaAllocator can be used to free the whole graph at once.  If an
+// allocation fails, everything allocated so far is freed and the error is returned.
+func (v *Point) MarshalC(a cgoalloc.Allocator) (unsafe.Pointer, error) {
+	tracked := cgoalloc.CreateArenaAllocator(a)
+	dst, err := cgoalloc.NewE[cgoallocPoint](tracked)
+	if err == nil {
+		err = v.marshalCInto(tracked, dst)
+	}
+	if err != nil {
+		tracked.FreeAll()
+		return nil, err
+	}
+	return unsafe.Pointer(dst), nil
+}
+
+func (v *Point) marshalCInto(a cgoalloc.Allocator, dst *cgoallocPoint) error {
+	dst.X = v.X
+	dst.Y = v.Y
+	return nil
+}
+
+// UnmarshalC reads the C-layout struct at ptr into v.  ptr is not freed.
+func (v *Point) UnmarshalC(ptr unsafe.Pointer) {
+	v.unmarshalCFrom((*cgoallocPoint)(ptr))
+}
+
+func (v *Point) unmarshalCFrom(src *cgoallocPoint) {
+	v.X = src.X
+	v.Y = src.Y
+}
+
+// cgoallocShape is the C layout of Shape
+type cgoallocShape struct {
+	Name    unsafe.Pointer
+	Points  unsafe.Pointer
+	Count   uint32
+	Origin  cgoallocPoint
+	Corners [2]cgoallocPoint
+	Scale   unsafe.Pointer
+	Parent  unsafe.Pointer
+	Data    unsafe.Pointer
+	DataLen int32
+}
+
+// MarshalC writes a C-layout copy of v into memory allocated from a, and returns a pointer to it.  Strings, pointers
+// and slices are allocated from a as well, so an ArenaAllocator can be used to free the whole graph at once.  If an
+// allocation fails, everything allocated so far is freed and the error is returned.
+func (v *Shape) MarshalC(a cgoalloc.Allocator) (unsafe.Pointer, error) {
+	tracked := cgoalloc.CreateArenaAllocator(a)
+	dst, err := cgoalloc.NewE[cgoallocShape](tracked)
+	if err == nil {
+		err = v.marshalCInto(tracked, dst)
+	}
+	if err != nil {
+		tracked.FreeAll()
+		return nil, err
+	}
+	return unsafe.Pointer(dst), nil
+}
+
+func (v *Shape) marshalCInto(a cgoalloc.Allocator, dst *cgoallocShape) error {
+	var err error
+	dst.Name, err = cgoalloc.CStringPointerE(a, v.Name)
+	if err != nil {
+		return err
+	}
+	if len(v.Points) > 0 {
+		elems, err := cgoalloc.MakeSliceE[cgoallocPoint](a, len(v.Points))
+		if err != nil {
+			return err
+		}
+		for i := range v.Points {
+			if err := v.Points[i].marshalCInto(a, &elems[i]); err != nil {
+				return err
+			}
+		}
+		dst.Points = unsafe.Pointer(&elems[0])
+	}
+	dst.Count = uint32(len(v.Points))
+	if err := v.Origin.marshalCInto(a, &dst.Origin); err != nil {
+		return err
+	}
+	for i := range v.Corners {
+		if err := v.Corners[i].marshalCInto(a, &dst.Corners[i]); err != nil {
+			return err
+		}
+	}
+	if v.Scale != nil {
+		elem, err := cgoalloc.NewE[float64](a)
+		if err != nil {
+			return err
+		}
+		*elem = *v.Scale
+		dst.Scale = unsafe.Pointer(elem)
+	}
+	if v.Parent != nil {
+		elem, err := cgoalloc.NewE[cgoallocShape](a)
+		if err == nil {
+			err = v.Parent.marshalCInto(a, elem)
+		}
+		if err != nil {
+			return err
+		}
+		dst.Parent = unsafe.Pointer(elem)
+	}
+	if len(v.Data) > 0 {
+		ptr, err := cgoalloc.CopySliceE(a, v.Data)
+		if err != nil {
+			return err
+		}
+		dst.Data = ptr
+	}
+	dst.DataLen = int32(len(v.Data))
+	return nil
+}
+
+// UnmarshalC reads the C-layout struct at ptr into v.  ptr is not freed.
+func (v *Shape) UnmarshalC(ptr unsafe.Pointer) {
+	v.unmarshalCFrom((*cgoallocShape)(ptr))
+}
+
+func (v *Shape) unmarshalCFrom(src *cgoallocShape) {
+	v.Name = cgoallocGoString(src.Name)
+	v.Points = nil
+	if src.Points != nil && src.Count > 0 {
+		v.Points = make([]Point, src.Count)
+		for i, elem := range unsafe.Slice((*cgoallocPoint)(src.Points), src.Count) {
+			v.Points[i].unmarshalCFrom(&elem)
+		}
+	}
+	v.Count = src.Count
+	v.Origin.unmarshalCFrom(&src.Origin)
+	for i := range v.Corners {
+		v.Corners[i].unmarshalCFrom(&src.Corners[i])
+	}
+	v.Scale = nil
+	if src.Scale != nil {
+		elem := *(*float64)(src.Scale)
+		v.Scale = &elem
+	}
+	v.Parent = nil
+	if src.Parent != nil {
+		v.Parent = new(Shape)
+		v.Parent.UnmarshalC(src.Parent)
+	}
+	v.Data = nil
+	if src.Data != nil && src.DataLen > 0 {
+		v.Data = append([]uint8(nil), unsafe.Slice((*uint8)(src.Data), src.DataLen)...)
+	}
+	v.DataLen = src.DataLen
+}
+
+func cgoallocGoString(ptr unsafe.Pointer) string {
+	if ptr == nil {
+		return ""
+	}
+
+	length := 0
+	for *(*byte)(unsafe.Add(ptr, length)) != 0 {
+		length++
+	}
+	return string(cgoalloc.GoBytesView(ptr, length))
+}
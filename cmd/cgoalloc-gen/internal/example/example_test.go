@@ -0,0 +1,65 @@
+package example
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func testShape() Shape {
+	scale := 2.5
+	return Shape{
+		Name:    "triangle",
+		Points:  []Point{{X: 1, Y: 2}, {X: 3, Y: 4}, {X: 5, Y: 6}},
+		Origin:  Point{X: -1, Y: -2},
+		Corners: [2]Point{{X: 0, Y: 0}, {X: 10, Y: 10}},
+		Scale:   &scale,
+		Parent:  &Shape{Name: "parent", Points: []Point{{X: 7, Y: 8}}},
+		Data:    []uint8{1, 2, 3},
+		Cache:   map[string]int{"skipped": 1},
+	}
+}
+
+func TestGenerated_RoundTrip(t *testing.T) {
+	heap, err := cgoalloc.CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+	arena := cgoalloc.CreateArenaAllocator(heap)
+
+	shape := testShape()
+	ptr, err := shape.MarshalC(arena)
+	require.NoError(t, err)
+
+	var result Shape
+	result.UnmarshalC(ptr)
+	arena.FreeAll()
+
+	shape.Count = 3
+	shape.DataLen = 3
+	shape.Parent.Count = 1
+	shape.Cache = nil
+	require.Equal(t, shape, result)
+	require.NoError(t, arena.Destroy())
+}
+
+func TestGenerated_AllocationFailures(t *testing.T) {
+	shape := testShape()
+
+	// The shape, its name, points, scale and data, and the parent along with its name and points
+	for call := 1; call <= 8; call++ {
+		heap, err := cgoalloc.CreateGoHeapAllocator(4096)
+		require.NoError(t, err)
+		testAlloc := cgoalloctest.CreateRecordingAllocator(t, heap)
+		failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: call})
+
+		ptr, err := shape.MarshalC(failing)
+		require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory, "call %d", call)
+		require.Equal(t, unsafe.Pointer(nil), ptr)
+
+		allocs, frees := testAlloc.Record()
+		require.Len(t, allocs, call-1)
+		require.ElementsMatch(t, allocs, frees)
+		require.NoError(t, testAlloc.Destroy())
+	}
+}
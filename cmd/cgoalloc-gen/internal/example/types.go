@@ -0,0 +1,24 @@
+// Package example holds annotated structs and the code cgoalloc-gen generates for them, so that the generated code is
+// compiled and round-tripped along with the rest of the module
+package example
+
+//go:generate go run github.com/CannibalVox/cgoalloc/cmd/cgoalloc-gen
+
+//cgoalloc:marshal
+type Point struct {
+	X, Y float32
+}
+
+//cgoalloc:marshal
+type Shape struct {
+	Name    string  `c:"cstring"`
+	Points  []Point `c:"array,len=Count"`
+	Count   uint32
+	Origin  Point
+	Corners [2]Point
+	Scale   *float64 `c:"ptr"`
+	Parent  *Shape   `c:"ptr"`
+	Data    []uint8  `c:"array,len=DataLen"`
+	DataLen int32
+	Cache   map[string]int `c:"-"`
+}
@@ -0,0 +1,96 @@
+// Command cgoalloc-gen generates allocator-aware marshalling code for Go structs, as a compile-time alternative to
+// cgoalloc.Marshal.
+//
+// Struct types annotated with a //cgoalloc:marshal directive get a C-layout mirror struct along with two methods:
+//
+//	func (v *T) MarshalC(a cgoalloc.Allocator) (unsafe.Pointer, error)
+//	func (v *T) UnmarshalC(ptr unsafe.Pointer)
+//
+// Fields use the same c tags as cgoalloc.Marshal- cstring, ptr, array,len=Field and -.  Untagged fields are copied
+// as-is, and fields whose type is another annotated struct are laid out inline.  Unlike cgoalloc.Marshal, array fields
+// must name a length field, so that UnmarshalC knows how many elements to read.
+//
+// Usage:
+//
+//	cgoalloc-gen [-output file] [dir]
+//
+// The package in dir (the current directory by default) is scanned- only the files that go build would compile for the
+// current platform and build tags, not counting tests- and the generated code is written to
+// cgoalloc_gen.go in the same directory, unless -output says otherwise.  A typical use is a go:generate line:
+//
+//	//go:generate cgoalloc-gen
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const defaultOutput = "cgoalloc_gen.go"
+
+func main() {
+	output := flag.String("output", "", "output file name; default <dir>/"+defaultOutput)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: cgoalloc-gen [-output file] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	} else if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = filepath.Join(dir, defaultOutput)
+	}
+
+	if err := run(dir, outputPath); err != nil {
+		fmt.Fprintf(os.Stderr, "cgoalloc-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, outputPath string) error {
+	// go/build applies build constraints, so files which wouldn't be compiled don't contribute types
+	pkg, err := build.Default.ImportDir(dir, 0)
+	if err != nil {
+		return err
+	}
+
+	fileNames := make([]string, 0, len(pkg.GoFiles)+len(pkg.CgoFiles))
+	for _, fileName := range append(append([]string(nil), pkg.GoFiles...), pkg.CgoFiles...) {
+		if fileName != filepath.Base(outputPath) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	sort.Strings(fileNames)
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, fileName), nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	src, err := generate(fset, pkg.Name, files)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(outputPath, src, 0644)
+}
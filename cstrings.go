@@ -0,0 +1,138 @@
+//go:build cgo
+
+package cgoalloc
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"unsafe"
+)
+
+// Layouts recorded in the header word in front of a CStringArray
+const (
+	cStringArrayPacked uintptr = iota + 1
+	cStringArrayUnpacked
+)
+
+// CStringArray converts strs into a NULL-terminated char** array, such as an argv list, using the provided Allocator.
+// The pointer array and all of the strings are packed into a single allocation if the allocator can serve one that
+// large- otherwise, the array and each string are allocated separately.  The layout is recorded in a hidden word just
+// in front of the array, and the result must be freed with FreeCStringArray, using the same allocator.  It panics if
+// an allocation fails- use CStringArrayE to handle that instead.
+func CStringArray(allocator Allocator, strs []string) **C.char {
+	array, err := CStringArrayE(allocator, strs)
+	if err != nil {
+		panic(err)
+	}
+	return array
+}
+
+// CStringArrayE is equivalent to CStringArray, but returns an error instead of panicking if an allocation fails.
+// Nothing is left allocated when it fails.
+func CStringArrayE(allocator Allocator, strs []string) (**C.char, error) {
+	ptrSize := int(unsafe.Sizeof(uintptr(0)))
+	arraySize := (len(strs) + 1) * ptrSize
+	totalSize := arraySize
+	for _, str := range strs {
+		totalSize += len(str) + 1
+	}
+
+	buffer, err := TryMalloc(allocator, ptrSize+totalSize)
+	if errors.Is(err, ErrTooLarge) {
+		return mallocCStringArrayUnpacked(allocator, strs)
+	} else if err != nil {
+		return nil, err
+	}
+
+	*(*uintptr)(buffer) = cStringArrayPacked
+	array := unsafe.Add(buffer, ptrSize)
+	ptrs := unsafe.Slice((**C.char)(array), len(strs)+1)
+	offset := arraySize
+	for i, str := range strs {
+		strBuffer := unsafe.Slice((*byte)(unsafe.Add(array, offset)), len(str)+1)
+		copy(strBuffer, str)
+		strBuffer[len(str)] = 0
+
+		ptrs[i] = (*C.char)(unsafe.Pointer(&strBuffer[0]))
+		offset += len(str) + 1
+	}
+	ptrs[len(strs)] = nil
+
+	return (**C.char)(array), nil
+}
+
+// mallocCStringArrayUnpacked allocates the pointer array and then each string separately, for allocators that can't
+// serve the whole array at once
+func mallocCStringArrayUnpacked(allocator Allocator, strs []string) (**C.char, error) {
+	ptrSize := int(unsafe.Sizeof(uintptr(0)))
+	buffer, err := TryMalloc(allocator, ptrSize+(len(strs)+1)*ptrSize)
+	if err != nil {
+		return nil, err
+	}
+	*(*uintptr)(buffer) = cStringArrayUnpacked
+
+	array := unsafe.Add(buffer, ptrSize)
+	ptrs := unsafe.Slice((**C.char)(array), len(strs)+1)
+	for i, str := range strs {
+		ptr, err := mallocCString(allocator, str)
+		if err != nil {
+			ptrs[i] = nil
+			FreeCStringArray(allocator, (**C.char)(array))
+			return nil, err
+		}
+		ptrs[i] = (*C.char)(ptr)
+	}
+	ptrs[len(strs)] = nil
+	return (**C.char)(array), nil
+}
+
+// FreeCStringArray frees an array that was allocated with CStringArray.  It must be called with the same allocator that
+// allocated the array.  Allocations are freed in the reverse of the order they were made in, so stack allocators can
+// be used.
+func FreeCStringArray(allocator Allocator, array **C.char) {
+	ptrSize := int(unsafe.Sizeof(uintptr(0)))
+	buffer := unsafe.Add(unsafe.Pointer(array), -ptrSize)
+
+	switch *(*uintptr)(buffer) {
+	case cStringArrayPacked:
+	case cStringArrayUnpacked:
+		count := 0
+		for *(**C.char)(unsafe.Add(unsafe.Pointer(array), count*ptrSize)) != nil {
+			count++
+		}
+		for i := count - 1; i >= 0; i-- {
+			allocator.Free(*(*unsafe.Pointer)(unsafe.Add(unsafe.Pointer(array), i*ptrSize)))
+		}
+	default:
+		panic("cgoalloc: attempted to free a string array which was not allocated with CStringArray")
+	}
+
+	allocator.Free(buffer)
+}
+
+// GoStringView returns a Go string which aliases the NUL-terminated C string str, without copying it.  The returned
+// string is only valid for as long as the C memory is- once str is freed or modified, the string must not be used
+// again, and it must never be retained anywhere that might outlive str.  A nil str produces an empty string.
+func GoStringView(str *C.char) string {
+	if str == nil {
+		return ""
+	}
+
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(str)), cStrLen(unsafe.Pointer(str)))
+	return *(*string)(unsafe.Pointer(&bytes))
+}
+
+// GoStringAndFree copies the NUL-terminated C string str into a Go string, and then frees str with the provided
+// Allocator.  A nil str produces an empty string and is not freed.
+func GoStringAndFree(allocator Allocator, str *C.char) string {
+	if str == nil {
+		return ""
+	}
+
+	goStr := string(GoBytesView(unsafe.Pointer(str), cStrLen(unsafe.Pointer(str))))
+	allocator.Free(unsafe.Pointer(str))
+	return goStr
+}
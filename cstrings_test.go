@@ -0,0 +1,118 @@
+//go:build cgo
+
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func readCStringArray(array unsafe.Pointer) []string {
+	var strs []string
+	for str := *(*unsafe.Pointer)(array); str != nil; str = *(*unsafe.Pointer)(array) {
+		strs = append(strs, string(unsafe.Slice((*byte)(str), cgoalloc.CStrLen(str))))
+		array = unsafe.Add(array, unsafe.Sizeof(str))
+	}
+	return strs
+}
+
+func TestCStringArray_Packed(t *testing.T) {
+	fba, err := cgoalloc.CreateFixedBlockAllocator(&cgoalloc.DefaultAllocator{}, 256, 64, 8)
+	require.NoError(t, err)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, fba)
+
+	array := cgoalloc.CStringArray(testAlloc, []string{"a", "bc"})
+	require.Equal(t, []string{"a", "bc"}, readCStringArray(unsafe.Pointer(array)))
+	cgoalloc.FreeCStringArray(testAlloc, array)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{37}, allocs)
+	require.Equal(t, []int{37}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestCStringArray_Unpacked(t *testing.T) {
+	fba, err := cgoalloc.CreateFixedBlockAllocator(&cgoalloc.DefaultAllocator{}, 256, 64, 8)
+	require.NoError(t, err)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, fba)
+
+	strs := []string{"hello", "", "abcdefghijklmnopqrstuvwxyz"}
+	array := cgoalloc.CStringArray(testAlloc, strs)
+	require.Equal(t, strs, readCStringArray(unsafe.Pointer(array)))
+	cgoalloc.FreeCStringArray(testAlloc, array)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{40, 6, 1, 27}, allocs)
+	require.Equal(t, []int{27, 1, 6, 40}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestCStringArray_Errors(t *testing.T) {
+	// Running out of memory doesn't fall back to the unpacked layout, which would only run out again
+	failing := cgoalloctest.CreateFaultInjectingAllocator(&cgoalloc.DefaultAllocator{}, cgoalloctest.FaultOptions{FailOnCall: 1})
+	_, err := cgoalloc.CStringArrayE(failing, []string{"a"})
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	// A failure partway through the unpacked layout frees everything allocated so far
+	fba, err := cgoalloc.CreateFixedBlockAllocator(&cgoalloc.DefaultAllocator{}, 256, 64, 8)
+	require.NoError(t, err)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, fba)
+	failing = cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: 5})
+	_, err = cgoalloc.CStringArrayE(failing, []string{"hello", "abcdefghijklmnopqrstuvwxyz", "world"})
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{40, 6, 27}, allocs)
+	require.Equal(t, []int{27, 6, 40}, frees)
+	require.NoError(t, testAlloc.Destroy())
+
+	_, err = cgoalloc.CStringArrayE(fba, []string{"a"})
+	require.ErrorIs(t, err, cgoalloc.ErrDestroyed)
+}
+
+func TestGoStrings_ReadAndFree(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, &cgoalloc.DefaultAllocator{})
+
+	str := cgoalloc.CString(testAlloc, "hello")
+	view := cgoalloc.GoStringView(str)
+	require.Equal(t, "hello", view)
+	*(*byte)(unsafe.Pointer(str)) = 'j'
+	require.Equal(t, "jello", view)
+	require.Equal(t, "jello", cgoalloc.GoStringAndFree(testAlloc, str))
+
+	bytes := cgoalloc.CBytes(testAlloc, []byte{1, 2, 3, 4})
+	bytesView := cgoalloc.GoBytesView(bytes, 4)
+	bytesView[0] = 5
+	require.Equal(t, []byte{5, 2, 3}, cgoalloc.GoBytesAndFree(testAlloc, bytes, 3))
+
+	str = nil
+	require.Equal(t, "", cgoalloc.GoStringView(str))
+	require.Equal(t, "", cgoalloc.GoStringAndFree(testAlloc, str))
+	require.Nil(t, cgoalloc.GoBytesAndFree(testAlloc, nil, 3))
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{6, 4}, allocs)
+	require.Equal(t, []int{6, 4}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestCStringE(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, &cgoalloc.DefaultAllocator{})
+	alloc, err := cgoalloc.CreateStackAllocator(testAlloc, 8, 8)
+	require.NoError(t, err)
+
+	_, err = cgoalloc.CStringE(alloc, "this string is too long")
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+	require.Panics(t, func() {
+		_ = cgoalloc.CString(alloc, "this string is too long")
+	})
+
+	str, err := cgoalloc.CStringE(alloc, "short")
+	require.NoError(t, err)
+	require.Equal(t, "short", cgoalloc.GoStringAndFree(alloc, str))
+
+	require.NoError(t, alloc.Destroy())
+}
@@ -0,0 +1,5 @@
+package cgoalloc
+
+// Internals used by the tests in cgoalloc_test
+
+var CStrLen = cStrLen
@@ -1,39 +1,76 @@
 package cgoalloc
 
 import (
+	"fmt"
 	"unsafe"
 )
 
-// FallbackAllocator is an Allocator implementation which accepts a FixedBlockAllocator and sends all Malloc calls which
-// can fit in the FBA's block size to that FixedBlockAllocator.  All other calls are sent to a fallback allocator.
+// TierAllocator is an Allocator which only accepts Malloc calls up to a maximum size, and which can tell whether it
+// owns a given pointer.  Any TierAllocator, such as a FixedBlockAllocator or BuddyAllocator, can be used as the first
+// tier of a FallbackAllocator.
+type TierAllocator interface {
+	Allocator
+	// MaxAllocSize returns the largest size that can be passed to Malloc
+	MaxAllocSize() int
+	// Owns returns true if ptr lies within memory managed by this Allocator, which makes this Allocator responsible
+	// for freeing it.  It says nothing about whether ptr is a live allocation.
+	Owns(ptr unsafe.Pointer) bool
+	// TryFree returns false and does nothing if this Allocator doesn't own ptr.  Otherwise, it frees ptr exactly as Free
+	// would, and returns true.
+	TryFree(ptr unsafe.Pointer) bool
+}
+
+// FallbackAllocator is an Allocator implementation which accepts a TierAllocator and sends all Malloc calls which
+// fit within the tier's MaxAllocSize to that TierAllocator.  All other calls are sent to a fallback allocator.
 type FallbackAllocator struct {
-	fixedBlock FixedBlockAllocator
+	tier TierAllocator
 	fallback Allocator
+
+	destroyed bool
 }
 
-func CreateFallbackAllocator(fixedBlock FixedBlockAllocator, fallback Allocator) *FallbackAllocator {
+func CreateFallbackAllocator(tier TierAllocator, fallback Allocator) *FallbackAllocator {
 	return &FallbackAllocator{
-		fixedBlock: fixedBlock,
+		tier: tier,
 		fallback: fallback,
 	}
 }
 
 func (a *FallbackAllocator) Malloc(size int) unsafe.Pointer {
-	if size > a.fixedBlock.assignedBlockSize() {
+	if a.destroyed {
+		panic(fmt.Errorf("fallbackallocator: %w", ErrDestroyed))
+	}
+	if size > a.tier.MaxAllocSize() {
 		return a.fallback.Malloc(size)
 	}
 
-	return a.fixedBlock.Malloc(size)
+	return a.tier.Malloc(size)
+}
+
+func (a *FallbackAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("fallbackallocator: %w", ErrDestroyed)
+	}
+	if size > a.tier.MaxAllocSize() {
+		return TryMalloc(a.fallback, size)
+	}
+
+	return TryMalloc(a.tier, size)
 }
 
 func (a *FallbackAllocator) Free(ptr unsafe.Pointer) {
-	if !a.fixedBlock.tryFree(ptr) {
+	if !a.tier.TryFree(ptr) {
 		a.fallback.Free(ptr)
 	}
 }
 
 func (a *FallbackAllocator) Destroy() error {
-	err := a.fixedBlock.Destroy()
+	if a.destroyed {
+		return nil
+	}
+
+	err := a.tier.Destroy()
 	if err != nil { return err }
+	a.destroyed = true
 	return a.fallback.Destroy()
 }
@@ -1,21 +1,23 @@
-package cgoalloc
+package cgoalloc_test
 
 import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
 func TestThresholdAlloc(t *testing.T) {
-	test1 := CreateTestAllocator(t, &DefaultAllocator{})
+	test1 := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
 
-	test2FBA, err := CreateFixedBlockAllocator(&DefaultAllocator{}, 64, 64, 8)
+	test2FBA, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 64, 64, 8)
 	if err != nil {
 		t.FailNow()
 	}
-	test2 := CreateTestAllocator(t, test2FBA)
+	test2 := cgoalloctest.CreateRecordingAllocator(t, test2FBA)
 
-	thresholdAllocator := CreateFallbackAllocator(test2, test1)
-	defer require.NoError(t, thresholdAllocator.Destroy())
+	thresholdAllocator := cgoalloc.CreateFallbackAllocator(test2, test1)
+	defer func() { require.NoError(t, thresholdAllocator.Destroy()) }()
 
 	a1 := thresholdAllocator.Malloc(8)
 	a2 := thresholdAllocator.Malloc(20)
@@ -43,3 +45,30 @@ func TestThresholdAlloc(t *testing.T) {
 	require.ElementsMatch(t, allocs, []int{8, 20, 64})
 	require.ElementsMatch(t, frees, []int{8, 20, 64})
 }
+
+func TestFallback_BuddyTier(t *testing.T) {
+	fallback := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	buddy, err := cgoalloc.CreateBuddyAllocator(createInnerAllocator(t), 4, 8)
+	require.NoError(t, err)
+	tier := cgoalloctest.CreateRecordingAllocator(t, buddy)
+
+	alloc := cgoalloc.CreateFallbackAllocator(tier, fallback)
+
+	a1 := alloc.Malloc(8)
+	b1 := alloc.Malloc(300)
+	a2 := alloc.Malloc(256)
+
+	alloc.Free(b1)
+	alloc.Free(a1)
+	alloc.Free(a2)
+	require.NoError(t, alloc.Destroy())
+
+	allocs, frees := fallback.Record()
+	require.Equal(t, []int{300}, allocs)
+	require.Equal(t, []int{300}, frees)
+
+	allocs, frees = tier.Record()
+	require.Equal(t, []int{8, 256}, allocs)
+	require.ElementsMatch(t, []int{8, 256}, frees)
+}
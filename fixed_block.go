@@ -1,12 +1,9 @@
 package cgoalloc
 
-/*
-#include <stdlib.h>
-*/
-import "C"
 import (
 	"container/heap"
 	"errors"
+	"fmt"
 	"sort"
 	"unsafe"
 )
@@ -22,9 +19,8 @@ import (
 // page will be freed.  Otherwise, Malloc and Free calls made to this Allocator will simply shuffle around block pointers
 // with no cgo interaction at all.
 type FixedBlockAllocator interface {
-	Allocator
-	tryFree(ptr unsafe.Pointer) bool
-	assignedBlockSize() int
+	TierAllocator
+	TryAllocator
 }
 
 type fixedBlockAllocatorImpl struct {
@@ -41,6 +37,11 @@ type fixedBlockAllocatorImpl struct {
 	pageStarts []uintptr
 	pages          map[uintptr]*page
 	freeBlockQueue pagePQueue
+
+	destroyed bool
+
+	// pageReleased is called with every block in a page just before the page is freed
+	pageReleased func(blocks []unsafe.Pointer)
 }
 
 // CreateFixedBlockAllocator creates a new FixedBlockAllocator with the provided properties.
@@ -71,36 +72,53 @@ func CreateFixedBlockAllocator(inner Allocator, pageSize , blockSize, alignment
 	}, nil
 }
 
-func (a *fixedBlockAllocatorImpl) assignedBlockSize() int { return int(a.blockSize)}
+// MaxAllocSize returns the block size- Malloc calls requesting more than this will panic
+func (a *fixedBlockAllocatorImpl) MaxAllocSize() int { return int(a.blockSize)}
+
+// Owns returns true if ptr is located in one of this allocator's pages
+func (a *fixedBlockAllocatorImpl) Owns(ptr unsafe.Pointer) bool {
+	return a.findPage(ptr) != nil
+}
 
 func (a *fixedBlockAllocatorImpl) Destroy() error {
+	if a.destroyed {
+		return nil
+	}
+
 	blocks := a.blocksPerPage * len(a.pages)
 	if blocks > a.allFreeBlocks {
 		return errors.New("fixedblockallocator: attempted to Destroy, but not all allocations had been freed")
 	}
 
 	for _, page := range a.pages {
-		a.inner.Free(unsafe.Pointer(page.pageStart))
+		if a.pageReleased != nil {
+			a.pageReleased(page.freeBlocks)
+		}
+		a.inner.Free(page.region)
 	}
 
+	a.destroyed = true
 	return nil
 }
 
-func (a *fixedBlockAllocatorImpl) allocatePage() {
+func (a *fixedBlockAllocatorImpl) allocatePage() error {
 	// Allocate page memory
 	size := int(a.pageSize+a.alignment)
-	pagePtr := a.inner.Malloc(size)
+	pagePtr, err := TryMalloc(a.inner, size)
+	if err != nil {
+		return err
+	}
 
 	// Get page bounds & create page
 	pageStart := uintptr(pagePtr)
-	page := &page{index: -1, pageTicket: a.nextPageTicket, pageStart: pageStart, freeBlocks: make([]unsafe.Pointer, a.blocksPerPage)}
+	page := &page{index: -1, pageTicket: a.nextPageTicket, pageStart: pageStart, region: pagePtr, freeBlocks: make([]unsafe.Pointer, a.blocksPerPage)}
 	a.nextPageTicket++
 
 	// Calculate block pointers
-	block := unsafe.Pointer((pageStart+a.alignment) - (pageStart % a.alignment))
+	block := unsafe.Add(pagePtr, a.alignment - (pageStart % a.alignment))
 	for i := 0; i < a.blocksPerPage; i++ {
 		page.freeBlocks[i] = block
-		block = unsafe.Add(block, C.int(a.blockSize))
+		block = unsafe.Add(block, a.blockSize)
 	}
 
 	// Add page to allocator
@@ -118,6 +136,7 @@ func (a *fixedBlockAllocatorImpl) allocatePage() {
 	heap.Push(&a.freeBlockQueue, page)
 
 	a.pages[pageStart] = page
+	return nil
 }
 
 func (a *fixedBlockAllocatorImpl) deallocatePage(page *page) {
@@ -132,16 +151,32 @@ func (a *fixedBlockAllocatorImpl) deallocatePage(page *page) {
 	a.freeBlockQueue.Remove(page)
 
 	a.allFreeBlocks -= a.blocksPerPage
-	a.inner.Free(unsafe.Pointer(page.pageStart))
+	if a.pageReleased != nil {
+		a.pageReleased(page.freeBlocks)
+	}
+	a.inner.Free(page.region)
 }
 
 func (a *fixedBlockAllocatorImpl) Malloc(size int) unsafe.Pointer {
+	block, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+func (a *fixedBlockAllocatorImpl) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("fixed block allocator: %w", ErrDestroyed)
+	}
 	if size > int(a.blockSize) {
-		panic("fixed block allocator: requested allocation larger than block size")
+		return nil, fmt.Errorf("fixed block allocator: requested allocation larger than block size: %w", ErrTooLarge)
 	}
 
 	if a.allFreeBlocks == 0 {
-		a.allocatePage()
+		if err := a.allocatePage(); err != nil {
+			return nil, fmt.Errorf("fixed block allocator: failed to allocate a page: %w", err)
+		}
 	}
 
 	page := a.freeBlockQueue[0]
@@ -158,19 +193,19 @@ func (a *fixedBlockAllocatorImpl) Malloc(size int) unsafe.Pointer {
 	}
 
 	a.allFreeBlocks--
-	return block
+	return block, nil
 }
 
 func (a *fixedBlockAllocatorImpl) Free(block unsafe.Pointer) {
-	if !a.tryFree(block) {
+	if !a.TryFree(block) {
 		panic("fixed block allocator: attempted to free a block not located in an allocated page")
 	}
 }
 
-func (a *fixedBlockAllocatorImpl) tryFree(block unsafe.Pointer) bool {
+func (a *fixedBlockAllocatorImpl) findPage(block unsafe.Pointer) *page {
 	pageStartsLen := len(a.pageStarts)
 	if pageStartsLen == 0 {
-		return false
+		return nil
 	}
 
 	//Find the page this block belongs to
@@ -183,14 +218,22 @@ func (a *fixedBlockAllocatorImpl) tryFree(block unsafe.Pointer) bool {
 	})
 
 	if pageStartIdx >= pageStartsLen {
-		return false
+		return nil
 	}
 
 	pageStart := a.pageStarts[pageStartIdx]
 	if pageStart > blockPtr {
+		return nil
+	}
+	return a.pages[pageStart]
+}
+
+// TryFree returns block to its page if it is located in one of this allocator's pages
+func (a *fixedBlockAllocatorImpl) TryFree(block unsafe.Pointer) bool {
+	page := a.findPage(block)
+	if page == nil {
 		return false
 	}
-	page := a.pages[pageStart]
 
 	// Return the block
 	page.freeBlocks = append(page.freeBlocks, block)
@@ -1,13 +1,16 @@
-package cgoalloc
+package cgoalloc_test
 
 import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"unsafe"
 )
 
 func TestFixedBlock_TenAllocs_OnePage(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc, err := CreateFixedBlockAllocator(testAlloc, 160, 8, 8)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(testAlloc, 160, 8, 8)
 	require.NoError(t, err)
 
 	a1 := alloc.Malloc(8)
@@ -45,8 +48,8 @@ func TestFixedBlock_TenAllocs_OnePage(t *testing.T) {
 }
 
 func TestFixedBlock_TenAllocs_ThreePages(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc, err := CreateFixedBlockAllocator(testAlloc, 32, 8, 8)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(testAlloc, 32, 8, 8)
 	require.NoError(t, err)
 
 	a1 := alloc.Malloc(8)
@@ -85,8 +88,8 @@ func TestFixedBlock_TenAllocs_ThreePages(t *testing.T) {
 }
 
 func TestFixedBlock_TenAllocs_ThreePagesMultipleLive(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc, err := CreateFixedBlockAllocator(testAlloc, 32, 8, 8)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(testAlloc, 32, 8, 8)
 	require.NoError(t, err)
 
 	a1 := alloc.Malloc(8)
@@ -137,10 +140,10 @@ func TestFixedBlock_TenAllocs_ThreePagesMultipleLive(t *testing.T) {
 }
 
 func TestFixedBlock_FourPagesUpTwoDown(t *testing.T) {
-	testAlloc := CreateTestAllocator(t, &DefaultAllocator{})
-	alloc, err := CreateFixedBlockAllocator(testAlloc, 16, 8, 8)
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(testAlloc, 16, 8, 8)
 	require.NoError(t, err)
-	defer require.NoError(t, alloc.Destroy())
+	defer func() { require.NoError(t, alloc.Destroy()) }()
 
 	a1 := alloc.Malloc(2)
 	a2 := alloc.Malloc(2)
@@ -169,3 +172,38 @@ func TestFixedBlock_FourPagesUpTwoDown(t *testing.T) {
 	alloc.Free(a7)
 }
 
+
+func TestFixedBlock_TryMalloc(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(testAlloc, 64, 8, 8)
+	require.NoError(t, err)
+
+	_, err = alloc.TryMalloc(16)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	ptr, err := cgoalloc.TryMalloc(alloc, 8)
+	require.NoError(t, err)
+	alloc.Free(ptr)
+
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFixedBlock_Owns(t *testing.T) {
+	alloc, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 64, 8, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(8)
+	a2 := alloc.Malloc(8)
+	require.True(t, alloc.Owns(a1))
+
+	// a1 has been freed, but it's still inside one of the allocator's pages
+	alloc.Free(a1)
+	require.True(t, alloc.Owns(a1))
+
+	var outside [8]byte
+	require.False(t, alloc.Owns(unsafe.Pointer(&outside)))
+	require.False(t, alloc.TryFree(unsafe.Pointer(&outside)))
+
+	require.True(t, alloc.TryFree(a2))
+	require.NoError(t, alloc.Destroy())
+}
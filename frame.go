@@ -0,0 +1,140 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// FrameAllocator is an Allocator implementation which divides a single buffer allocated from an inner Allocator into
+// a fixed number of equally-sized frames.  Malloc calls are served by bumping a pointer forward through the current
+// frame, and Free calls do nothing at all- instead, BeginFrame resets an entire frame in O(1), at which point any
+// memory that had been allocated from that frame is considered freed.
+//
+// This matches the frames-in-flight model used by graphics APIs: data allocated during frame i lives until frame i
+// comes back around again, by which point the consumer of that data is known to be done with it.  Malloc calls which
+// do not fit in the remainder of the current frame will panic.
+type FrameAllocator struct {
+	inner Allocator
+
+	region unsafe.Pointer
+	start  unsafe.Pointer
+
+	frameCount int
+	frameSize  uintptr
+	alignment  uintptr
+
+	currentFrame int
+	offset       uintptr
+}
+
+// CreateFrameAllocator creates a new FrameAllocator with the provided properties.
+// inner - The backing buffer for all frames is allocated using this Allocator
+// frameCount - The number of frames that can be in flight at once
+// frameSize - The number of bytes available to each frame.  Must be a multiple of alignment.
+// alignment - All allocated pointers will be along this byte alignment.
+func CreateFrameAllocator(inner Allocator, frameCount int, frameSize, alignment uintptr) (*FrameAllocator, error) {
+	if frameCount < 1 {
+		return nil, errors.New("frameallocator: framecount must be at least 1")
+	}
+	if alignment == 0 {
+		return nil, errors.New("frameallocator: alignment must be greater than 0")
+	}
+	if frameSize%alignment != 0 {
+		return nil, errors.New("frameallocator: framesize must be a multiple of alignment")
+	}
+	if frameSize > (math.MaxInt-alignment)/uintptr(frameCount) {
+		return nil, fmt.Errorf("frameallocator: framecount * framesize overflows: %w", ErrTooLarge)
+	}
+
+	region, err := TryMalloc(inner, int(uintptr(frameCount)*frameSize+alignment))
+	if err != nil {
+		return nil, fmt.Errorf("frameallocator: could not allocate the frames: %w", err)
+	}
+	padding := (alignment - uintptr(region)%alignment) % alignment
+
+	return &FrameAllocator{
+		inner: inner,
+
+		region: region,
+		start:  unsafe.Add(region, padding),
+
+		frameCount: frameCount,
+		frameSize:  frameSize,
+		alignment:  alignment,
+	}, nil
+}
+
+// BeginFrame makes frame the current frame and resets it, freeing everything that was allocated during that frame
+// the last time it was current.  Subsequent Malloc calls will be served from this frame.
+func (a *FrameAllocator) BeginFrame(frame int) {
+	if frame < 0 || frame >= a.frameCount {
+		panic("frameallocator: attempted to begin a frame index outside of the allocated frames")
+	}
+
+	a.currentFrame = frame
+	a.offset = 0
+}
+
+// CurrentFrame returns the index of the frame that Malloc calls are currently being served from
+func (a *FrameAllocator) CurrentFrame() int { return a.currentFrame }
+
+// FrameCount returns the number of frames managed by this FrameAllocator
+func (a *FrameAllocator) FrameCount() int { return a.frameCount }
+
+func (a *FrameAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *FrameAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.start == nil {
+		return nil, fmt.Errorf("frameallocator: %w", ErrDestroyed)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("frameallocator: requested a negative allocation size %d", size)
+	}
+
+	alignedSize := uintptr(size)
+	if remainder := alignedSize % a.alignment; remainder != 0 {
+		alignedSize += a.alignment - remainder
+	}
+
+	if alignedSize > a.frameSize {
+		return nil, fmt.Errorf("frameallocator: requested allocation larger than a frame: %w", ErrTooLarge)
+	}
+	if a.offset+alignedSize > a.frameSize {
+		return nil, fmt.Errorf("frameallocator: requested allocation does not fit in the remainder of the current frame: %w", ErrOutOfMemory)
+	}
+
+	ptr := unsafe.Add(a.start, uintptr(a.currentFrame)*a.frameSize+a.offset)
+	a.offset += alignedSize
+	return ptr, nil
+}
+
+// Free does not release any memory- memory is released a frame at a time by BeginFrame.  It will panic if the
+// pointer was not allocated by this FrameAllocator.
+func (a *FrameAllocator) Free(ptr unsafe.Pointer) {
+	start := uintptr(a.start)
+	end := start + uintptr(a.frameCount)*a.frameSize
+	if uintptr(ptr) < start || uintptr(ptr) >= end {
+		panic("frameallocator: attempted to free a pointer which had not been allocated with this allocator")
+	}
+}
+
+// Destroy frees the backing buffer.  Because frames are reset wholesale, a FrameAllocator has no way of knowing
+// whether any allocations are still in use, so it never reports a leak.  Calling Destroy more than once does nothing.
+func (a *FrameAllocator) Destroy() error {
+	if a.region == nil {
+		return nil
+	}
+
+	a.inner.Free(a.region)
+	a.region = nil
+	a.start = nil
+	return nil
+}
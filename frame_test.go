@@ -0,0 +1,71 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestFrame_ReuseFrames(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateFrameAllocator(testAlloc, 2, 32, 8)
+	require.NoError(t, err)
+
+	alloc.BeginFrame(0)
+	a1 := alloc.Malloc(8)
+	a2 := alloc.Malloc(3)
+	a3 := alloc.Malloc(16)
+	require.Equal(t, uintptr(8), uintptr(a2)-uintptr(a1))
+	require.Equal(t, uintptr(16), uintptr(a3)-uintptr(a1))
+	require.Zero(t, uintptr(a1)%8)
+
+	alloc.BeginFrame(1)
+	b1 := alloc.Malloc(32)
+	require.Equal(t, uintptr(32), uintptr(b1)-uintptr(a1))
+	alloc.Free(b1)
+
+	alloc.BeginFrame(0)
+	require.Equal(t, a1, alloc.Malloc(8))
+
+	require.NoError(t, alloc.Destroy())
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{72}, allocs)
+	require.Equal(t, []int{72}, frees)
+}
+
+func TestFrame_FrameFull(t *testing.T) {
+	alloc, err := cgoalloc.CreateFrameAllocator(createInnerAllocator(t), 2, 16, 8)
+	require.NoError(t, err)
+
+	alloc.BeginFrame(1)
+	_ = alloc.Malloc(16)
+	require.Panics(t, func() {
+		_ = alloc.Malloc(1)
+	})
+	require.Panics(t, func() {
+		alloc.BeginFrame(2)
+	})
+	_, err = alloc.TryMalloc(-8)
+	require.Error(t, err)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestFrame_CreateErrors(t *testing.T) {
+	failing := cgoalloctest.CreateFaultInjectingAllocator(createInnerAllocator(t), cgoalloctest.FaultOptions{FailOnCall: 1})
+	_, err := cgoalloc.CreateFrameAllocator(failing, 2, 16, 8)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	_, err = cgoalloc.CreateFrameAllocator(failing, 4, math.MaxInt/2, 1)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	// A second Destroy must not free the region again through an inner allocator that would panic
+	inner, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 256, 64, 8)
+	require.NoError(t, err)
+	alloc, err := cgoalloc.CreateFrameAllocator(inner, 2, 16, 8)
+	require.NoError(t, err)
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, inner.Destroy())
+}
@@ -0,0 +1,301 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// GCCouplingOptions describes how a GCCoupling responds to growth in live C memory
+type GCCouplingOptions struct {
+	// Ratio is how far live C bytes must grow past the live C bytes at the end of the last GC before another GC is
+	// triggered, as a fraction of the latter- so 1, the default, triggers a GC whenever live C memory doubles, much
+	// like GOGC=100 does for the Go heap.
+	Ratio float64
+	// MinBytes is the smallest change in live C bytes that will trigger a GC or a memory limit update.  Defaults to
+	// 4MB.
+	MinBytes int64
+	// MemoryLimit, if set, changes how the coupling responds: instead of triggering GCs directly, the Go runtime's soft
+	// memory limit is set to MemoryLimit minus the live C bytes, so that the GC works harder as C memory fills the
+	// process's share of memory.  The memory limit is global to the process, so only one GCCoupling at a time may use
+	// this mode.  The previous memory limit is restored by Stop, unless something else has changed it in the meantime.
+	MemoryLimit int64
+	// MinMemoryLimit is the lowest the memory limit will be set to, however much C memory is live, so that the GC
+	// doesn't end up running continuously once C memory alone exceeds MemoryLimit.  Defaults to a quarter of
+	// MemoryLimit.
+	MinMemoryLimit int64
+}
+
+// GCCouplingStats is a snapshot of a GCCoupling's state
+type GCCouplingStats struct {
+	// LiveBytes is the number of bytes currently allocated through allocators coupled to the GCCoupling
+	LiveBytes int64
+	// BytesAtLastGC is the number of live bytes at the end of the most recent GC
+	BytesAtLastGC int64
+	// TriggeredGCs is the number of GCs the GCCoupling has triggered and seen finish
+	TriggeredGCs int64
+}
+
+// GCCoupling tracks the live C bytes allocated across every GCCoupledAllocator created from it, and nudges the Go
+// garbage collector when they grow.  This matters when C memory is freed by finalizers: the GC only sees the small Go
+// objects that own the C memory, so without help it may not run often enough to keep the process's footprint bounded.
+//
+// The coupling detects GCs with a finalizer sentinel, which re-arms itself after every GC until Stop is called.  A
+// GCCoupling that is no longer needed must be stopped, or it will never be collected.
+type GCCoupling struct {
+	ratio          float64
+	minBytes       int64
+	memoryLimit    int64
+	minMemoryLimit int64
+
+	liveBytes     atomic.Int64
+	bytesAtLastGC atomic.Int64
+	triggeredGCs  atomic.Int64
+	collecting    atomic.Bool
+	stopped       atomic.Bool
+
+	limitLock      sync.Mutex
+	limitAppliedAt int64
+	appliedLimit   int64
+	previousLimit  int64
+}
+
+// limitCoupling is the GCCoupling managing the process's memory limit, if any
+var limitCoupling atomic.Pointer[GCCoupling]
+
+type gcSentinel struct {
+	coupling *GCCoupling
+}
+
+// CreateGCCoupling creates a new GCCoupling with the provided options and starts watching for GCs.  It fails if
+// MemoryLimit is set while another GCCoupling that sets it is still running.
+func CreateGCCoupling(opts GCCouplingOptions) (*GCCoupling, error) {
+	if opts.Ratio < 0 || opts.MinBytes < 0 || opts.MemoryLimit < 0 || opts.MinMemoryLimit < 0 {
+		return nil, errors.New("gccoupling: options must not be negative")
+	}
+	if opts.MinMemoryLimit > opts.MemoryLimit {
+		return nil, errors.New("gccoupling: minmemorylimit must not be greater than memorylimit")
+	}
+	if opts.Ratio == 0 {
+		opts.Ratio = 1
+	}
+	if opts.MinBytes == 0 {
+		opts.MinBytes = 4 << 20
+	}
+	if opts.MinMemoryLimit == 0 {
+		opts.MinMemoryLimit = opts.MemoryLimit / 4
+	}
+
+	coupling := &GCCoupling{
+		ratio:          opts.Ratio,
+		minBytes:       opts.MinBytes,
+		memoryLimit:    opts.MemoryLimit,
+		minMemoryLimit: opts.MinMemoryLimit,
+	}
+	if coupling.memoryLimit > 0 {
+		if !limitCoupling.CompareAndSwap(nil, coupling) {
+			return nil, errors.New("gccoupling: another coupling is already managing the memory limit")
+		}
+		coupling.appliedLimit = coupling.memoryLimit
+		coupling.previousLimit = debug.SetMemoryLimit(coupling.memoryLimit)
+	}
+
+	coupling.armSentinel()
+	return coupling, nil
+}
+
+func (c *GCCoupling) armSentinel() {
+	runtime.SetFinalizer(&gcSentinel{coupling: c}, func(sentinel *gcSentinel) {
+		if sentinel.coupling.stopped.Load() {
+			return
+		}
+
+		sentinel.coupling.collected()
+		sentinel.coupling.armSentinel()
+	})
+}
+
+// collected is called after every GC
+func (c *GCCoupling) collected() {
+	c.bytesAtLastGC.Store(c.liveBytes.Load())
+}
+
+// account adds size, which may be negative, to the live C bytes and responds to the change
+func (c *GCCoupling) account(size int64) {
+	live := c.liveBytes.Add(size)
+
+	if c.memoryLimit > 0 {
+		c.updateMemoryLimit(live)
+		return
+	}
+
+	base := c.bytesAtLastGC.Load()
+	growth := live - base
+	if growth < c.minBytes || float64(growth) < c.ratio*float64(base) {
+		return
+	}
+
+	// Only one GC at a time- everyone else who crosses the threshold while it's running can rely on it
+	if c.collecting.CompareAndSwap(false, true) {
+		go func() {
+			runtime.GC()
+			c.collected()
+			c.triggeredGCs.Add(1)
+			c.collecting.Store(false)
+		}()
+	}
+}
+
+func (c *GCCoupling) updateMemoryLimit(live int64) {
+	c.limitLock.Lock()
+	defer c.limitLock.Unlock()
+
+	change := live - c.limitAppliedAt
+	if change < 0 {
+		change = -change
+	}
+	if change < c.minBytes || c.stopped.Load() {
+		return
+	}
+
+	limit := c.memoryLimit - live
+	if limit < c.minMemoryLimit {
+		limit = c.minMemoryLimit
+	}
+
+	c.limitAppliedAt = live
+	c.appliedLimit = limit
+	debug.SetMemoryLimit(limit)
+}
+
+// LiveBytes returns the number of bytes currently allocated through allocators coupled to this GCCoupling
+func (c *GCCoupling) LiveBytes() int64 {
+	return c.liveBytes.Load()
+}
+
+// Stats returns a snapshot of the coupling's live bytes and GC activity
+func (c *GCCoupling) Stats() GCCouplingStats {
+	return GCCouplingStats{
+		LiveBytes:     c.liveBytes.Load(),
+		BytesAtLastGC: c.bytesAtLastGC.Load(),
+		TriggeredGCs:  c.triggeredGCs.Load(),
+	}
+}
+
+// Stop stops watching for GCs and, if the coupling was managing the memory limit, restores the limit that was in
+// place when it was created- unless the limit has been changed by something else since the coupling last set it, in
+// which case that change is left alone.  Either way, another GCCoupling may then manage the limit.  Allocators coupled to a stopped GCCoupling continue to track live bytes, but no longer
+// influence the GC.
+func (c *GCCoupling) Stop() {
+	if c.stopped.Swap(true) {
+		return
+	}
+
+	if c.memoryLimit > 0 {
+		c.limitLock.Lock()
+		defer c.limitLock.Unlock()
+
+		if debug.SetMemoryLimit(-1) == c.appliedLimit {
+			debug.SetMemoryLimit(c.previousLimit)
+		}
+		limitCoupling.CompareAndSwap(c, nil)
+	}
+}
+
+// GCCoupledAllocator sits on top of another Allocator and reports the size of every allocation and free to a
+// GCCoupling.  GCCoupledAllocator is safe for concurrent use if the inner Allocator is.
+type GCCoupledAllocator struct {
+	inner    Allocator
+	coupling *GCCoupling
+
+	lock       sync.Mutex
+	allocSizes map[unsafe.Pointer]int
+	destroyed  bool
+}
+
+// CreateGCCoupledAllocator creates a new GCCoupledAllocator which allocates from inner and reports to coupling
+func CreateGCCoupledAllocator(coupling *GCCoupling, inner Allocator) *GCCoupledAllocator {
+	return &GCCoupledAllocator{
+		inner:    inner,
+		coupling: coupling,
+
+		allocSizes: make(map[unsafe.Pointer]int),
+	}
+}
+
+func (a *GCCoupledAllocator) track(ptr unsafe.Pointer, size int) {
+	a.lock.Lock()
+	a.allocSizes[ptr] = size
+	a.lock.Unlock()
+
+	a.coupling.account(int64(size))
+}
+
+func (a *GCCoupledAllocator) isDestroyed() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.destroyed
+}
+
+func (a *GCCoupledAllocator) Malloc(size int) unsafe.Pointer {
+	if a.isDestroyed() {
+		panic(fmt.Errorf("gccoupledallocator: %w", ErrDestroyed))
+	}
+
+	ptr := a.inner.Malloc(size)
+	if ptr != nil {
+		a.track(ptr, size)
+	}
+	return ptr
+}
+
+func (a *GCCoupledAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.isDestroyed() {
+		return nil, fmt.Errorf("gccoupledallocator: %w", ErrDestroyed)
+	}
+
+	ptr, err := TryMalloc(a.inner, size)
+	if err != nil {
+		return nil, err
+	}
+
+	a.track(ptr, size)
+	return ptr, nil
+}
+
+func (a *GCCoupledAllocator) Free(ptr unsafe.Pointer) {
+	a.lock.Lock()
+	size, ok := a.allocSizes[ptr]
+	delete(a.allocSizes, ptr)
+	a.lock.Unlock()
+
+	if !ok {
+		panic(fmt.Sprintf("gccoupledallocator: attempted to free %p, which was not allocated by this allocator", ptr))
+	}
+
+	a.inner.Free(ptr)
+	a.coupling.account(-int64(size))
+}
+
+func (a *GCCoupledAllocator) Destroy() error {
+	a.lock.Lock()
+	live := len(a.allocSizes)
+	destroyed := a.destroyed
+	if live == 0 {
+		a.destroyed = true
+	}
+	a.lock.Unlock()
+
+	if live > 0 {
+		return errors.New("gccoupledallocator: attempted to Destroy but not all allocations have been freed")
+	}
+	if destroyed {
+		return nil
+	}
+	return a.inner.Destroy()
+}
@@ -0,0 +1,127 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestGCCoupling_TriggersGC(t *testing.T) {
+	coupling, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{Ratio: 1, MinBytes: 1024})
+	require.NoError(t, err)
+	defer coupling.Stop()
+
+	alloc := cgoalloc.CreateGCCoupledAllocator(coupling, cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t)))
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	gcsBefore := stats.NumGC
+
+	small := alloc.Malloc(512)
+	require.Equal(t, int64(512), coupling.LiveBytes())
+
+	large := alloc.Malloc(2048)
+	require.Eventually(t, func() bool {
+		stats := coupling.Stats()
+		return stats.TriggeredGCs == 1 && stats.BytesAtLastGC == 2560
+	}, time.Second, time.Millisecond)
+
+	runtime.ReadMemStats(&stats)
+	require.Greater(t, stats.NumGC, gcsBefore)
+
+	alloc.Free(small)
+	alloc.Free(large)
+	require.Equal(t, int64(0), coupling.LiveBytes())
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestGCCoupling_DetectsGC(t *testing.T) {
+	coupling, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{})
+	require.NoError(t, err)
+	defer coupling.Stop()
+
+	alloc := cgoalloc.CreateGCCoupledAllocator(coupling, cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t)))
+	ptr := alloc.Malloc(64)
+
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		return coupling.Stats().BytesAtLastGC == 64
+	}, time.Second, time.Millisecond)
+
+	alloc.Free(ptr)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestGCCoupling_MemoryLimit(t *testing.T) {
+	previous := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() { debug.SetMemoryLimit(previous) })
+
+	coupling, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MinBytes: 1024, MemoryLimit: 1 << 40})
+	require.NoError(t, err)
+	t.Cleanup(coupling.Stop)
+	require.Equal(t, int64(1<<40), debug.SetMemoryLimit(-1))
+
+	_, err = cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MemoryLimit: 1 << 40})
+	require.Error(t, err)
+
+	alloc := cgoalloc.CreateGCCoupledAllocator(coupling, cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t)))
+
+	small := alloc.Malloc(512)
+	require.Equal(t, int64(1<<40), debug.SetMemoryLimit(-1))
+	large := alloc.Malloc(4096)
+	require.Equal(t, int64(1<<40-4608), debug.SetMemoryLimit(-1))
+
+	alloc.Free(large)
+	require.Equal(t, int64(1<<40-512), debug.SetMemoryLimit(-1))
+	alloc.Free(small)
+
+	coupling.Stop()
+	require.Equal(t, previous, debug.SetMemoryLimit(-1))
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestGCCoupling_MemoryLimitFloor(t *testing.T) {
+	previous := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() { debug.SetMemoryLimit(previous) })
+
+	coupling, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MinBytes: 1024, MemoryLimit: 8192, MinMemoryLimit: 2048})
+	require.NoError(t, err)
+	t.Cleanup(coupling.Stop)
+
+	alloc := cgoalloc.CreateGCCoupledAllocator(coupling, cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t)))
+
+	ptr := alloc.Malloc(16384)
+	require.Equal(t, int64(2048), debug.SetMemoryLimit(-1))
+	alloc.Free(ptr)
+	require.Equal(t, int64(8192), debug.SetMemoryLimit(-1))
+
+	coupling.Stop()
+	require.Equal(t, previous, debug.SetMemoryLimit(-1))
+	require.NoError(t, alloc.Destroy())
+
+	_, err = cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MemoryLimit: 1024, MinMemoryLimit: 2048})
+	require.Error(t, err)
+}
+
+func TestGCCoupling_MemoryLimitChangedElsewhere(t *testing.T) {
+	previous := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() { debug.SetMemoryLimit(previous) })
+
+	coupling, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MemoryLimit: 1 << 40})
+	require.NoError(t, err)
+	t.Cleanup(coupling.Stop)
+
+	debug.SetMemoryLimit(1 << 41)
+	coupling.Stop()
+	require.Equal(t, int64(1<<41), debug.SetMemoryLimit(-1))
+
+	// Once stopped, the memory limit is free for another coupling to manage
+	next, err := cgoalloc.CreateGCCoupling(cgoalloc.GCCouplingOptions{MemoryLimit: 1 << 40})
+	require.NoError(t, err)
+	next.Stop()
+	require.Equal(t, int64(1<<41), debug.SetMemoryLimit(-1))
+}
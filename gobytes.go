@@ -0,0 +1,55 @@
+package cgoalloc
+
+import "unsafe"
+
+// cStrLen returns the length of a NUL-terminated string, not including the terminator
+func cStrLen(str unsafe.Pointer) int {
+	length := 0
+	for *(*byte)(unsafe.Add(str, length)) != 0 {
+		length++
+	}
+	return length
+}
+
+// mallocCString copies str into a NUL-terminated buffer allocated with the provided Allocator
+func mallocCString(allocator Allocator, str string) (unsafe.Pointer, error) {
+	ptr, err := TryMalloc(allocator, len(str)+1)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := unsafe.Slice((*byte)(ptr), len(str)+1)
+	copy(buffer, str)
+	buffer[len(str)] = 0
+	return ptr, nil
+}
+
+// CStringPointerE is equivalent to CStringE, but returns the string as an unsafe.Pointer rather than a *C.char, so that
+// it can be used from packages which don't import "C"
+func CStringPointerE(allocator Allocator, str string) (unsafe.Pointer, error) {
+	return mallocCString(allocator, str)
+}
+
+// GoBytesView returns a Go slice which aliases the n bytes of C memory at ptr, without copying them.  The returned slice
+// is only valid for as long as the C memory is- once ptr is freed, the slice must not be used again, and it must never
+// be retained anywhere that might outlive ptr.
+func GoBytesView(ptr unsafe.Pointer, n int) []byte {
+	if ptr == nil || n == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(ptr), n)
+}
+
+// GoBytesAndFree copies the n bytes of C memory at ptr into a Go slice, and then frees ptr with the provided Allocator.
+// A nil ptr produces a nil slice and is not freed.
+func GoBytesAndFree(allocator Allocator, ptr unsafe.Pointer, n int) []byte {
+	if ptr == nil {
+		return nil
+	}
+
+	goBytes := make([]byte, n)
+	copy(goBytes, GoBytesView(ptr, n))
+	allocator.Free(ptr)
+	return goBytes
+}
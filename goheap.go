@@ -0,0 +1,111 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+const goHeapAlignment = 16
+
+type goHeapChunk struct {
+	data   []byte
+	pinner runtime.Pinner
+}
+
+// pinnedGoHeapChunks holds every allocated chunk, so that a GoHeapAllocator which is dropped without being destroyed
+// leaks its chunks the way C memory would, instead of crashing the runtime when its Pinners are collected while pinned
+var pinnedGoHeapChunks sync.Map
+
+// goHeapChunks is an Allocator which serves each Malloc with its own pinned Go byte slice.  It is the page source for
+// GoHeapAllocator.
+type goHeapChunks struct {
+	chunks map[unsafe.Pointer]*goHeapChunk
+}
+
+func (c *goHeapChunks) Malloc(size int) unsafe.Pointer {
+	chunk := &goHeapChunk{data: make([]byte, size)}
+	ptr := unsafe.Pointer(&chunk.data[0])
+	chunk.pinner.Pin(ptr)
+
+	c.chunks[ptr] = chunk
+	pinnedGoHeapChunks.Store(ptr, chunk)
+	return ptr
+}
+
+func (c *goHeapChunks) Free(ptr unsafe.Pointer) {
+	chunk, ok := c.chunks[ptr]
+	if !ok {
+		panic("goheapallocator: attempted to free a chunk which had not been allocated with this allocator")
+	}
+
+	chunk.pinner.Unpin()
+	delete(c.chunks, ptr)
+	pinnedGoHeapChunks.Delete(ptr)
+}
+
+func (c *goHeapChunks) Destroy() error { return nil }
+
+// GoHeapAllocator is an Allocator implementation which doesn't use C memory at all.  Instead, it allocates large
+// chunks of memory as Go byte slices, pins them, and serves Malloc calls from those chunks with a TLSFAllocator.
+// Because it doesn't require cgo, it's available when building with CGO_ENABLED=0, and it's a quick way to unit test
+// code that accepts an Allocator without a C toolchain.
+//
+// This is still Go memory.  It may be passed to C for the duration of a call, but C must not retain it, and the GC can't
+// see any Go pointers stored in it.
+type GoHeapAllocator struct {
+	chunks    *goHeapChunks
+	tlsf      *TLSFAllocator
+	destroyed bool
+}
+
+// CreateGoHeapAllocator creates a new GoHeapAllocator which allocates Go memory in chunks of chunkSize bytes.  Malloc
+// calls larger than chunkSize are given a chunk of their own.  All allocated pointers are 16-byte aligned.
+func CreateGoHeapAllocator(chunkSize uintptr) (*GoHeapAllocator, error) {
+	chunks := &goHeapChunks{chunks: make(map[unsafe.Pointer]*goHeapChunk)}
+
+	if remainder := chunkSize % goHeapAlignment; remainder != 0 {
+		chunkSize += goHeapAlignment - remainder
+	}
+	tlsf, err := CreateTLSFAllocator(chunks, chunkSize, goHeapAlignment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GoHeapAllocator{
+		chunks: chunks,
+		tlsf:   tlsf,
+	}, nil
+}
+
+func (a *GoHeapAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *GoHeapAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("goheapallocator: %w", ErrDestroyed)
+	}
+	return a.tlsf.TryMalloc(size)
+}
+
+func (a *GoHeapAllocator) Free(ptr unsafe.Pointer) {
+	a.tlsf.Free(ptr)
+}
+
+func (a *GoHeapAllocator) Destroy() error {
+	if a.destroyed {
+		return nil
+	}
+
+	if err := a.tlsf.Destroy(); err != nil {
+		return err
+	}
+	a.destroyed = true
+	return nil
+}
@@ -0,0 +1,48 @@
+package cgoalloc
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func TestGoHeap_Chunks(t *testing.T) {
+	alloc, err := CreateGoHeapAllocator(100)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(8)
+	a2 := alloc.Malloc(200)
+	a3 := alloc.Malloc(33)
+	require.Zero(t, uintptr(a1)%16)
+	require.Zero(t, uintptr(a3)%16)
+	require.Equal(t, uintptr(16), uintptr(a3)-uintptr(a1))
+	require.Len(t, alloc.chunks.chunks, 2)
+
+	copy(unsafe.Slice((*byte)(a2), 200), make([]byte, 200))
+	alloc.Free(a2)
+	require.Len(t, alloc.chunks.chunks, 1)
+
+	require.Error(t, alloc.Destroy())
+	alloc.Free(a1)
+	alloc.Free(a3)
+	require.NoError(t, alloc.Destroy())
+	require.Len(t, alloc.chunks.chunks, 0)
+}
+
+func TestGoHeap_Helpers(t *testing.T) {
+	alloc, err := CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+
+	buffer := CreateCBuffer(alloc, 0)
+	_, err = buffer.WriteString("hello")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buffer.Bytes()))
+	buffer.Release()
+
+	slice := MakeSlice[uint64](alloc, 4)
+	slice[3] = 7
+	require.Equal(t, []uint64{0, 0, 0, 7}, slice)
+	FreeSlice(alloc, slice)
+
+	require.NoError(t, alloc.Destroy())
+}
@@ -0,0 +1,14 @@
+//go:build cgo
+
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"testing"
+)
+
+// createInnerAllocator returns the allocator the tests build their allocators on top of: C memory when cgo is
+// available, so the tests exercise the real thing, and Go memory otherwise
+func createInnerAllocator(t *testing.T) cgoalloc.Allocator {
+	return &cgoalloc.DefaultAllocator{}
+}
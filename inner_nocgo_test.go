@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func createInnerAllocator(t *testing.T) cgoalloc.Allocator {
+	alloc, err := cgoalloc.CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+	return alloc
+}
@@ -0,0 +1,339 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+type cTypeKind int
+
+const (
+	// cKindValue types have the same layout in Go and C, and are copied directly
+	cKindValue cTypeKind = iota
+	cKindStruct
+	cKindArray
+	cKindCString
+	cKindPointer
+	cKindSlice
+)
+
+type cField struct {
+	name   string
+	index  int
+	offset uintptr
+	ctype  *cType
+
+	// lenField is the index in cType.fields of the field that receives the length of this slice field, or -1
+	lenField int
+}
+
+type cType struct {
+	kind  cTypeKind
+	size  uintptr
+	align uintptr
+
+	fields []cField
+	elem   *cType
+	length int
+}
+
+var cTypeCache sync.Map
+
+var pointerSize = unsafe.Sizeof(unsafe.Pointer(nil))
+
+// Marshal writes a C-layout equivalent of the Go struct value- which may also be a pointer to a struct- into memory
+// allocated from the provided Allocator, and returns a pointer to it.  Fields are laid out in declaration order using
+// C alignment rules.  Fields with the following kinds are copied as-is: booleans, sized integers and floats,
+// uintptr and unsafe.Pointer, along with fixed-size arrays of those and nested structs, which are laid out inline.
+// Note that int and uint are the size of a pointer, so sized integers should be used to match C's int and long.
+//
+// Other fields must be tagged to describe how they should be marshalled:
+//
+//	Name  string    `c:"cstring"`          // char*, allocated with CString
+//	Child *Child    `c:"ptr"`              // Child*, marshalled into a separate allocation, or NULL
+//	Items []Item    `c:"array,len=Count"`  // Item*, marshalled into a separate allocation, or NULL if empty
+//	Count uint32                            // set to len(Items) when marshalling
+//	Cache *GoOnly   `c:"-"`                // skipped entirely
+//
+// Every nested pointer and string is allocated from the same Allocator as the struct itself.  Marshal doesn't keep
+// track of them, so the easiest way to release a marshalled struct is to marshal it with an ArenaAllocator and call
+// FreeAll when done.  Marshal returns an error without allocating anything if the struct can't be marshalled, and if
+// an allocation fails partway through, everything allocated so far is freed before the error is returned.
+//
+// For hot paths, the cgoalloc-gen command can generate equivalent marshalling code ahead of time, without reflection.
+func Marshal(allocator Allocator, value interface{}) (unsafe.Pointer, error) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, errors.New("marshal: attempted to marshal a nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("marshal: attempted to marshal a %s, but only structs can be marshalled", rv.Type())
+	}
+
+	ctype, err := cTypeOf(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	if !rv.CanAddr() {
+		// Field values are copied out of memory directly, so we need an addressable copy
+		addressable := reflect.New(rv.Type()).Elem()
+		addressable.Set(rv)
+		rv = addressable
+	}
+
+	m := &marshaller{allocator: allocator}
+	ptr, err := m.mallocZeroed(ctype.size)
+	if err == nil {
+		err = m.writeCValue(ptr, rv, ctype)
+	}
+	if err != nil {
+		m.freeAll()
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return ptr, nil
+}
+
+// marshaller keeps track of the allocations made while marshalling a value, so they can be freed if a later one fails
+type marshaller struct {
+	allocator   Allocator
+	allocations []unsafe.Pointer
+}
+
+func (m *marshaller) mallocZeroed(size uintptr) (unsafe.Pointer, error) {
+	ptr, err := CallocE(m.allocator, 1, int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	m.allocations = append(m.allocations, ptr)
+	return ptr, nil
+}
+
+// freeAll frees every allocation made so far, in the reverse of the order they were made in
+func (m *marshaller) freeAll() {
+	for i := len(m.allocations) - 1; i >= 0; i-- {
+		if m.allocations[i] != nil {
+			m.allocator.Free(m.allocations[i])
+		}
+	}
+	m.allocations = nil
+}
+
+func cTypeOf(t reflect.Type) (*cType, error) {
+	if cached, ok := cTypeCache.Load(t); ok {
+		return cached.(*cType), nil
+	}
+
+	ctype, err := buildCType(t, make(map[reflect.Type]*cType))
+	if err != nil {
+		return nil, err
+	}
+
+	cTypeCache.Store(t, ctype)
+	return ctype, nil
+}
+
+func alignOffset(offset, align uintptr) uintptr {
+	return (offset + align - 1) &^ (align - 1)
+}
+
+func buildCType(t reflect.Type, inProgress map[reflect.Type]*cType) (*cType, error) {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return &cType{kind: cKindValue, size: t.Size(), align: uintptr(t.Align())}, nil
+	case reflect.Array:
+		elem, err := buildCType(t.Elem(), inProgress)
+		if err != nil {
+			return nil, err
+		}
+		return &cType{kind: cKindArray, size: elem.size * uintptr(t.Len()), align: elem.align, elem: elem, length: t.Len()}, nil
+	case reflect.Struct:
+		return buildCStruct(t, inProgress)
+	default:
+		return nil, fmt.Errorf("marshal: type %s cannot be marshalled", t)
+	}
+}
+
+func buildCStruct(t reflect.Type, inProgress map[reflect.Type]*cType) (*cType, error) {
+	if ctype, ok := inProgress[t]; ok {
+		return ctype, nil
+	}
+
+	ctype := &cType{kind: cKindStruct, align: 1}
+	inProgress[t] = ctype
+
+	var offset uintptr
+	fieldIndices := make(map[string]int)
+	lenFields := make(map[int]string)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("c")
+		if tag == "-" {
+			continue
+		}
+
+		fieldType, lenField, err := buildCFieldType(field, tag, hasTag, inProgress)
+		if err != nil {
+			return nil, err
+		}
+
+		offset = alignOffset(offset, fieldType.align)
+		if lenField != "" {
+			lenFields[len(ctype.fields)] = lenField
+		}
+		fieldIndices[field.Name] = len(ctype.fields)
+		ctype.fields = append(ctype.fields, cField{name: field.Name, index: i, offset: offset, ctype: fieldType, lenField: -1})
+
+		offset += fieldType.size
+		if fieldType.align > ctype.align {
+			ctype.align = fieldType.align
+		}
+	}
+
+	for sliceIndex, lenName := range lenFields {
+		lenIndex, ok := fieldIndices[lenName]
+		if !ok {
+			return nil, fmt.Errorf("marshal: field %s of %s refers to length field %s, which does not exist", ctype.fields[sliceIndex].name, t, lenName)
+		}
+
+		switch t.Field(ctype.fields[lenIndex].index).Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			return nil, fmt.Errorf("marshal: length field %s of %s must be an integer", lenName, t)
+		}
+
+		ctype.fields[sliceIndex].lenField = lenIndex
+	}
+
+	ctype.size = alignOffset(offset, ctype.align)
+	return ctype, nil
+}
+
+func buildCFieldType(field reflect.StructField, tag string, hasTag bool, inProgress map[reflect.Type]*cType) (*cType, string, error) {
+	if !hasTag {
+		switch field.Type.Kind() {
+		case reflect.String, reflect.Pointer, reflect.Slice:
+			return nil, "", fmt.Errorf("marshal: field %s is a %s, which needs a c tag to be marshalled", field.Name, field.Type)
+		}
+
+		fieldType, err := buildCType(field.Type, inProgress)
+		return fieldType, "", err
+	}
+
+	options := strings.Split(tag, ",")
+	lenField := ""
+	for _, option := range options[1:] {
+		if !strings.HasPrefix(option, "len=") || options[0] != "array" {
+			return nil, "", fmt.Errorf("marshal: field %s has unknown c tag option %q", field.Name, option)
+		}
+		lenField = strings.TrimPrefix(option, "len=")
+	}
+
+	switch options[0] {
+	case "cstring":
+		if field.Type.Kind() != reflect.String {
+			return nil, "", fmt.Errorf("marshal: field %s is tagged cstring, but is a %s", field.Name, field.Type)
+		}
+		return &cType{kind: cKindCString, size: pointerSize, align: pointerSize}, "", nil
+	case "ptr":
+		if field.Type.Kind() != reflect.Pointer {
+			return nil, "", fmt.Errorf("marshal: field %s is tagged ptr, but is a %s", field.Name, field.Type)
+		}
+		elem, err := buildCType(field.Type.Elem(), inProgress)
+		if err != nil {
+			return nil, "", err
+		}
+		return &cType{kind: cKindPointer, size: pointerSize, align: pointerSize, elem: elem}, "", nil
+	case "array":
+		if field.Type.Kind() != reflect.Slice {
+			return nil, "", fmt.Errorf("marshal: field %s is tagged array, but is a %s", field.Name, field.Type)
+		}
+		elem, err := buildCType(field.Type.Elem(), inProgress)
+		if err != nil {
+			return nil, "", err
+		}
+		return &cType{kind: cKindSlice, size: pointerSize, align: pointerSize, elem: elem}, lenField, nil
+	default:
+		return nil, "", fmt.Errorf("marshal: field %s has unknown c tag %q", field.Name, tag)
+	}
+}
+
+// writeCValue writes the addressable value v to dst, which has been zeroed, according to ctype
+func (m *marshaller) writeCValue(dst unsafe.Pointer, v reflect.Value, ctype *cType) error {
+	switch ctype.kind {
+	case cKindValue:
+		src := unsafe.Pointer(v.UnsafeAddr())
+		copy(unsafe.Slice((*byte)(dst), ctype.size), unsafe.Slice((*byte)(src), ctype.size))
+	case cKindArray:
+		for i := 0; i < ctype.length; i++ {
+			if err := m.writeCValue(unsafe.Add(dst, uintptr(i)*ctype.elem.size), v.Index(i), ctype.elem); err != nil {
+				return err
+			}
+		}
+	case cKindStruct:
+		for _, field := range ctype.fields {
+			if err := m.writeCValue(unsafe.Add(dst, field.offset), v.Field(field.index), field.ctype); err != nil {
+				return err
+			}
+		}
+		for _, field := range ctype.fields {
+			if field.lenField >= 0 {
+				lenField := ctype.fields[field.lenField]
+				lenValue := reflect.NewAt(v.Field(lenField.index).Type(), unsafe.Add(dst, lenField.offset)).Elem()
+				writeCLength(lenValue, v.Field(field.index).Len())
+			}
+		}
+	case cKindCString:
+		ptr, err := mallocCString(m.allocator, v.String())
+		if err != nil {
+			return err
+		}
+		m.allocations = append(m.allocations, ptr)
+		*(*unsafe.Pointer)(dst) = ptr
+	case cKindPointer:
+		if v.IsNil() {
+			return nil
+		}
+		ptr, err := m.mallocZeroed(ctype.elem.size)
+		if err != nil {
+			return err
+		}
+		*(*unsafe.Pointer)(dst) = ptr
+		return m.writeCValue(ptr, v.Elem(), ctype.elem)
+	case cKindSlice:
+		if v.Len() == 0 {
+			return nil
+		}
+		ptr, err := m.mallocZeroed(ctype.elem.size * uintptr(v.Len()))
+		if err != nil {
+			return err
+		}
+		*(*unsafe.Pointer)(dst) = ptr
+		for i := 0; i < v.Len(); i++ {
+			if err := m.writeCValue(unsafe.Add(ptr, uintptr(i)*ctype.elem.size), v.Index(i), ctype.elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeCLength(lenValue reflect.Value, length int) {
+	switch lenValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		lenValue.SetInt(int64(length))
+	default:
+		lenValue.SetUint(uint64(length))
+	}
+}
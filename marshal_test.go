@@ -0,0 +1,111 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+type marshalTestChild struct {
+	Value int32
+	Flag  bool
+}
+
+type marshalTestParent struct {
+	Name     string             `c:"cstring"`
+	Children []marshalTestChild `c:"array,len=count"`
+	Extra    *marshalTestChild  `c:"ptr"`
+	Missing  *marshalTestChild  `c:"ptr"`
+	count    uint16
+	Inline   [2]marshalTestChild
+	Ignored  map[string]int `c:"-"`
+	Scale    float64
+}
+
+type marshalTestParentC struct {
+	Name     unsafe.Pointer
+	Children unsafe.Pointer
+	Extra    unsafe.Pointer
+	Missing  unsafe.Pointer
+	count    uint16
+	Inline   [2]marshalTestChild
+	Scale    float64
+}
+
+func TestMarshal_Graph(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	arena := cgoalloc.CreateArenaAllocator(testAlloc)
+
+	ptr, err := cgoalloc.Marshal(arena, marshalTestParent{
+		Name:     "parent",
+		Children: []marshalTestChild{{Value: 1}, {Value: 2, Flag: true}, {Value: 3}},
+		Extra:    &marshalTestChild{Value: 4},
+		count:    100,
+		Inline:   [2]marshalTestChild{{Value: 5}, {Value: 6, Flag: true}},
+		Ignored:  map[string]int{"a": 1},
+		Scale:    0.5,
+	})
+	require.NoError(t, err)
+
+	parent := (*marshalTestParentC)(ptr)
+	require.Equal(t, "parent", string(cgoalloc.GoBytesView(parent.Name, cgoalloc.CStrLen(parent.Name))))
+	require.Equal(t, []marshalTestChild{{Value: 1}, {Value: 2, Flag: true}, {Value: 3}}, unsafe.Slice((*marshalTestChild)(parent.Children), 3))
+	require.Equal(t, marshalTestChild{Value: 4}, *(*marshalTestChild)(parent.Extra))
+	require.Equal(t, unsafe.Pointer(nil), parent.Missing)
+	require.Equal(t, uint16(3), parent.count)
+	require.Equal(t, [2]marshalTestChild{{Value: 5}, {Value: 6, Flag: true}}, parent.Inline)
+	require.Equal(t, 0.5, parent.Scale)
+
+	arena.FreeAll()
+	allocs, frees := testAlloc.Record()
+	require.ElementsMatch(t, []int{int(unsafe.Sizeof(marshalTestParentC{})), 7, 24, 8}, allocs)
+	require.ElementsMatch(t, allocs, frees)
+	require.NoError(t, arena.Destroy())
+}
+
+func TestMarshal_Errors(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	_, err := cgoalloc.Marshal(testAlloc, struct{ Name string }{})
+	require.EqualError(t, err, "marshal: field Name is a string, which needs a c tag to be marshalled")
+
+	_, err = cgoalloc.Marshal(testAlloc, struct {
+		Values []int32 `c:"array,len=Count"`
+	}{})
+	require.EqualError(t, err, "marshal: field Values of struct { Values []int32 \"c:\\\"array,len=Count\\\"\" } refers to length field Count, which does not exist")
+
+	_, err = cgoalloc.Marshal(testAlloc, struct {
+		Value int32 `c:"cstring"`
+	}{})
+	require.EqualError(t, err, "marshal: field Value is tagged cstring, but is a int32")
+
+	_, err = cgoalloc.Marshal(testAlloc, 5)
+	require.Error(t, err)
+
+	allocs, _ := testAlloc.Record()
+	require.Len(t, allocs, 0)
+}
+
+func TestMarshal_AllocationFailure(t *testing.T) {
+	value := marshalTestParent{
+		Name:     "parent",
+		Children: []marshalTestChild{{Value: 1}},
+		Extra:    &marshalTestChild{Value: 4},
+	}
+
+	// Fail each of the four allocations in turn
+	for call := 1; call <= 4; call++ {
+		testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+		failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: call})
+		ptr, err := cgoalloc.Marshal(failing, value)
+		require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+		require.Equal(t, unsafe.Pointer(nil), ptr)
+
+		allocs, frees := testAlloc.Record()
+		require.Len(t, allocs, call-1)
+		require.ElementsMatch(t, allocs, frees)
+		require.NoError(t, testAlloc.Destroy())
+	}
+}
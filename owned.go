@@ -0,0 +1,196 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// OwnedLeak describes an Owned value which was garbage collected without being closed
+type OwnedLeak struct {
+	// Type is the name of the Owned value's type parameter
+	Type string
+	// Pointer is the pointer that was leaked, and has since been freed by DrainOwned
+	Pointer unsafe.Pointer
+	// Stack is the call stack that created the Owned value.  It's only recorded while leak reports are enabled, so it
+	// is empty for values created before EnableOwnedLeakReports was called.
+	Stack []runtime.Frame
+}
+
+func (l OwnedLeak) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "cgoalloc: Owned[%s] %p was never closed and was freed by DrainOwned", l.Type, l.Pointer)
+	if len(l.Stack) > 0 {
+		builder.WriteString(", allocated at:")
+		for _, frame := range l.Stack {
+			fmt.Fprintf(&builder, "\n\t%s\n\t\t%s:%d", frame.Function, frame.File, frame.Line)
+		}
+	}
+	return builder.String()
+}
+
+var ownedLeakReporter atomic.Pointer[func(leak OwnedLeak)]
+
+// EnableOwnedLeakReports causes report to be called whenever DrainOwned frees a leaked Owned value, with the stack that
+// created it- for example, with log.Print.  Recording stacks has a cost, so this is meant for debugging.  Passing
+// nil disables leak reports.
+func EnableOwnedLeakReports(report func(leak OwnedLeak)) {
+	if report == nil {
+		ownedLeakReporter.Store(nil)
+		return
+	}
+	ownedLeakReporter.Store(&report)
+}
+
+// Owned holds a pointer to a T allocated from an Allocator, and frees it through that Allocator when Close is called.
+// If the Owned value is garbage collected without being closed, its finalizer queues the pointer, and it is freed the
+// next time DrainOwned is called for that Allocator- which NewOwned, Own and Close all do.  Most allocators aren't safe
+// for concurrent use, so the finalizer goroutine never touches the Allocator itself.  This is a safety net for values
+// with unclear lifetimes, not a replacement for Close: the GC can't see C memory, so it has no reason to hurry, and a
+// late free is only better than no free at all.  GCCoupledAllocator can help with that.
+//
+// Because the finalizer can run as soon as the Owned value is unreachable, code that is still using the pointer
+// returned by Get must keep the Owned value alive with runtime.KeepAlive.  The Allocator is used as a map key, so it
+// must be comparable, as pointers are.  Queued pointers keep their Allocator reachable until they're drained, so an
+// Allocator that is about to be destroyed should be passed to DrainOwned, or to DiscardOwned if its memory is released
+// wholesale on Destroy anyway.
+type Owned[T any] struct {
+	allocator Allocator
+	ptr       *T
+	stack     []uintptr
+}
+
+// NewOwned allocates a zeroed T using the provided Allocator and returns an Owned value holding it.  Because the GC
+// can't see into C memory, T must not contain any Go pointers.  It panics if the allocation fails- use NewOwnedE to
+// handle that instead.
+func NewOwned[T any](allocator Allocator) *Owned[T] {
+	DrainOwned(allocator)
+	ptr, err := NewE[T](allocator)
+	if err != nil {
+		panic(err)
+	}
+	// newOwned is called directly rather than through NewOwnedE, so that leak reports skip the same number of frames
+	return newOwned(allocator, ptr)
+}
+
+// NewOwnedE is equivalent to NewOwned, but returns an error instead of panicking if the allocation fails
+func NewOwnedE[T any](allocator Allocator) (*Owned[T], error) {
+	DrainOwned(allocator)
+	ptr, err := NewE[T](allocator)
+	if err != nil {
+		return nil, err
+	}
+	return newOwned(allocator, ptr), nil
+}
+
+// Own takes ownership of ptr, which must have been allocated from the provided Allocator, and returns an Owned value
+// holding it.  A nil ptr produces an Owned value that is already closed.
+func Own[T any](allocator Allocator, ptr *T) *Owned[T] {
+	DrainOwned(allocator)
+	return newOwned(allocator, ptr)
+}
+
+type leakedOwned struct {
+	ptr  unsafe.Pointer
+	leak OwnedLeak
+}
+
+var leakedOwnedLock sync.Mutex
+var leakedOwnedByAllocator = make(map[Allocator][]leakedOwned)
+
+// DrainOwned frees every pointer whose Owned value was garbage collected without being closed and which belongs to the
+// provided Allocator, reporting them if leak reports are enabled.  It returns the number of pointers freed.  It must be
+// called from whichever goroutine is allowed to use the Allocator, and before the Allocator is destroyed.
+func DrainOwned(allocator Allocator) int {
+	leakedOwnedLock.Lock()
+	leaked := leakedOwnedByAllocator[allocator]
+	delete(leakedOwnedByAllocator, allocator)
+	leakedOwnedLock.Unlock()
+
+	report := ownedLeakReporter.Load()
+	for _, owned := range leaked {
+		allocator.Free(owned.ptr)
+		if report != nil {
+			(*report)(owned.leak)
+		}
+	}
+	return len(leaked)
+}
+
+// DiscardOwned forgets every pointer whose Owned value was garbage collected without being closed and which belongs to
+// the provided Allocator, without freeing them or reporting them.  It returns the number of pointers discarded.  It's
+// meant for Allocators that are being destroyed, and whose memory goes with them, so that the queue doesn't keep them
+// reachable.
+func DiscardOwned(allocator Allocator) int {
+	leakedOwnedLock.Lock()
+	defer leakedOwnedLock.Unlock()
+
+	count := len(leakedOwnedByAllocator[allocator])
+	delete(leakedOwnedByAllocator, allocator)
+	return count
+}
+
+func newOwned[T any](allocator Allocator, ptr *T) *Owned[T] {
+	owned := &Owned[T]{allocator: allocator, ptr: ptr}
+	var zero T
+	if ptr == nil || unsafe.Sizeof(zero) == 0 {
+		// There's nothing for the finalizer to free- zero-sized values come from the Go heap
+		return owned
+	}
+
+	if ownedLeakReporter.Load() != nil {
+		// Skip runtime.Callers, newOwned and NewOwned or Own
+		stack := make([]uintptr, 32)
+		owned.stack = stack[:runtime.Callers(3, stack)]
+	}
+
+	runtime.SetFinalizer(owned, (*Owned[T]).finalize)
+	return owned
+}
+
+func (o *Owned[T]) finalize() {
+	var zero T
+	ptr := unsafe.Pointer(o.ptr)
+	leak := OwnedLeak{Type: fmt.Sprintf("%T", zero), Pointer: ptr}
+	if len(o.stack) > 0 {
+		frames := runtime.CallersFrames(o.stack)
+		for {
+			frame, more := frames.Next()
+			leak.Stack = append(leak.Stack, frame)
+			if !more {
+				break
+			}
+		}
+	}
+
+	leakedOwnedLock.Lock()
+	defer leakedOwnedLock.Unlock()
+
+	leakedOwnedByAllocator[o.allocator] = append(leakedOwnedByAllocator[o.allocator], leakedOwned{ptr: ptr, leak: leak})
+}
+
+// Get returns the owned pointer, or nil if the Owned value has been closed
+func (o *Owned[T]) Get() *T {
+	return o.ptr
+}
+
+// Pointer returns the owned pointer as an unsafe.Pointer, or nil if the Owned value has been closed
+func (o *Owned[T]) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(o.ptr)
+}
+
+// Close frees the owned pointer and clears the finalizer, then drains any leaked pointers belonging to the same
+// Allocator.  Calling Close more than once does nothing.
+func (o *Owned[T]) Close() {
+	runtime.SetFinalizer(o, nil)
+	if o.ptr == nil {
+		return
+	}
+
+	Free(o.allocator, o.ptr)
+	o.ptr = nil
+	DrainOwned(o.allocator)
+}
@@ -0,0 +1,115 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+type ownedTestStruct struct {
+	A int32
+	B float64
+}
+
+func TestOwned_Close(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	owned := cgoalloc.NewOwned[ownedTestStruct](testAlloc)
+	owned.Get().A = 5
+	require.Equal(t, int32(5), owned.Get().A)
+
+	owned.Close()
+	owned.Close()
+	require.Nil(t, owned.Get())
+	require.Equal(t, unsafe.Pointer(nil), owned.Pointer())
+
+	allocs, frees := testAlloc.Record()
+	require.Len(t, allocs, 1)
+	require.Len(t, frees, 1)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func leakOwned(allocator cgoalloc.Allocator) unsafe.Pointer {
+	return cgoalloc.NewOwned[ownedTestStruct](allocator).Pointer()
+}
+
+func TestOwned_Finalizer(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	var leaks []cgoalloc.OwnedLeak
+	cgoalloc.EnableOwnedLeakReports(func(leak cgoalloc.OwnedLeak) {
+		leaks = append(leaks, leak)
+	})
+	defer cgoalloc.EnableOwnedLeakReports(nil)
+
+	ptr := leakOwned(testAlloc)
+
+	// The finalizer only queues the pointer- nothing is freed until it's drained here, on the test goroutine
+	drained := 0
+	for i := 0; i < 100 && drained == 0; i++ {
+		runtime.GC()
+		drained = cgoalloc.DrainOwned(testAlloc)
+	}
+	require.Equal(t, 1, drained)
+	require.Len(t, leaks, 1)
+
+	leak := leaks[0]
+	require.Equal(t, ptr, leak.Pointer)
+	require.Equal(t, "cgoalloc_test.ownedTestStruct", leak.Type)
+	require.NotEmpty(t, leak.Stack)
+	require.True(t, strings.HasSuffix(leak.Stack[0].Function, "cgoalloc_test.leakOwned"))
+	require.Contains(t, leak.String(), "leakOwned")
+
+	_, frees := testAlloc.Record()
+	require.Len(t, frees, 1)
+	require.Equal(t, 0, cgoalloc.DrainOwned(testAlloc))
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestOwned_AllocationFailures(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{ByteBudget: 8})
+
+	owned, err := cgoalloc.NewOwnedE[ownedTestStruct](failing)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Nil(t, owned)
+	require.Panics(t, func() {
+		_ = cgoalloc.NewOwned[ownedTestStruct](failing)
+	})
+
+	// Neither a nil pointer nor a zero-sized value has anything for the finalizer to free
+	_ = cgoalloc.Own[ownedTestStruct](testAlloc, nil)
+	_ = cgoalloc.NewOwned[struct{}](testAlloc)
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		require.Equal(t, 0, cgoalloc.DrainOwned(testAlloc))
+	}
+
+	empty := cgoalloc.NewOwned[struct{}](testAlloc)
+	empty.Close()
+
+	allocs, frees := testAlloc.Record()
+	require.Empty(t, allocs)
+	require.Empty(t, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestOwned_Discard(t *testing.T) {
+	heap, err := cgoalloc.CreateGoHeapAllocator(4096)
+	require.NoError(t, err)
+
+	// The heap's memory goes to the GC along with the allocator, so the leaked value doesn't need to be freed
+	_ = leakOwned(heap)
+
+	discarded := 0
+	for i := 0; i < 100 && discarded == 0; i++ {
+		runtime.GC()
+		discarded = cgoalloc.DiscardOwned(heap)
+	}
+	require.Equal(t, 1, discarded)
+	require.Equal(t, 0, cgoalloc.DrainOwned(heap))
+}
@@ -8,6 +8,7 @@ import (
 type page struct {
 	pageTicket uint
 	pageStart uintptr
+	region unsafe.Pointer
 	freeBlocks []unsafe.Pointer
 
 	index int
@@ -0,0 +1,100 @@
+package cgoalloc
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// Pool is a typed slab of T values which lives in C memory.  It is built on a FixedBlockAllocator whose block size and
+// alignment are taken from unsafe.Sizeof and unsafe.Alignof of T, so there's no need to work them out by hand.
+//
+// A Pool can be given a constructor, which is run the first time each block is handed out by Get, and a destructor,
+// which is run on each constructed block when the page holding it is released.  Between those two points, values
+// returned to the Pool with Put are handed back out by Get as-is, so that expensive initialization only has to happen
+// once per block.  Blocks without a constructor are zeroed on first use.
+//
+// Because the GC can't see into C memory, T must not contain any Go pointers.
+type Pool[T any] struct {
+	allocator *fixedBlockAllocatorImpl
+
+	construct func(obj *T)
+	destruct  func(obj *T)
+
+	constructed map[unsafe.Pointer]struct{}
+}
+
+// CreatePool creates a new Pool with the provided properties.
+// inner - Pages are created using this Allocator
+// blocksPerPage - The number of T values held by each page
+// construct - If not nil, this is called with each block the first time it is returned from Get
+// destruct - If not nil, this is called with each constructed block when the page it lives in is freed
+func CreatePool[T any](inner Allocator, blocksPerPage int, construct, destruct func(obj *T)) (*Pool[T], error) {
+	if blocksPerPage < 1 {
+		return nil, errors.New("pool: blocksperpage must be at least 1")
+	}
+
+	var zero T
+	blockSize := unsafe.Sizeof(zero)
+	alignment := unsafe.Alignof(zero)
+	if blockSize == 0 {
+		blockSize = alignment
+	}
+
+	allocator, err := CreateFixedBlockAllocator(inner, blockSize*uintptr(blocksPerPage), blockSize, alignment)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &Pool[T]{
+		allocator: allocator.(*fixedBlockAllocatorImpl),
+
+		construct: construct,
+		destruct:  destruct,
+
+		constructed: make(map[unsafe.Pointer]struct{}),
+	}
+	pool.allocator.pageReleased = pool.pageReleased
+
+	return pool, nil
+}
+
+func (p *Pool[T]) pageReleased(blocks []unsafe.Pointer) {
+	for _, block := range blocks {
+		if _, ok := p.constructed[block]; !ok {
+			continue
+		}
+
+		if p.destruct != nil {
+			p.destruct((*T)(block))
+		}
+		delete(p.constructed, block)
+	}
+}
+
+// Get returns a T from the pool, running the constructor on it if this is the first time the block has been used
+func (p *Pool[T]) Get() *T {
+	block := p.allocator.Malloc(int(p.allocator.blockSize))
+	obj := (*T)(block)
+
+	if _, ok := p.constructed[block]; !ok {
+		var zero T
+		*obj = zero
+		if p.construct != nil {
+			p.construct(obj)
+		}
+		p.constructed[block] = struct{}{}
+	}
+
+	return obj
+}
+
+// Put returns a T that was retrieved with Get to the pool
+func (p *Pool[T]) Put(obj *T) {
+	p.allocator.Free(unsafe.Pointer(obj))
+}
+
+// Destroy frees all pages held by the pool, running the destructor on every constructed block.  It will return an
+// error without freeing anything if any T retrieved with Get has not been returned with Put.
+func (p *Pool[T]) Destroy() error {
+	return p.allocator.Destroy()
+}
@@ -0,0 +1,73 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+type poolTestStruct struct {
+	a uint8
+	b uint64
+	c uint16
+}
+
+func TestPool_SizeAndAlignment(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	pool, err := cgoalloc.CreatePool[poolTestStruct](testAlloc, 4, nil, nil)
+	require.NoError(t, err)
+
+	obj1 := pool.Get()
+	obj2 := pool.Get()
+	require.Zero(t, uintptr(unsafe.Pointer(obj1))%unsafe.Alignof(poolTestStruct{}))
+	require.Equal(t, unsafe.Sizeof(poolTestStruct{}), uintptr(unsafe.Pointer(obj1))-uintptr(unsafe.Pointer(obj2)))
+	require.Equal(t, poolTestStruct{}, *obj1)
+
+	pool.Put(obj1)
+	pool.Put(obj2)
+	require.NoError(t, pool.Destroy())
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{4*24 + 8}, allocs)
+	require.Equal(t, []int{4*24 + 8}, frees)
+}
+
+func TestPool_ConstructDestruct(t *testing.T) {
+	constructed := 0
+	destructed := 0
+	pool, err := cgoalloc.CreatePool[poolTestStruct](createInnerAllocator(t), 2,
+		func(obj *poolTestStruct) {
+			constructed++
+			obj.b = 5
+		},
+		func(obj *poolTestStruct) {
+			require.Equal(t, uint64(5), obj.b)
+			destructed++
+		})
+	require.NoError(t, err)
+
+	obj1 := pool.Get()
+	require.Equal(t, uint64(5), obj1.b)
+	obj1.c = 3
+	pool.Put(obj1)
+
+	// Reused blocks aren't constructed again
+	obj1 = pool.Get()
+	require.Equal(t, uint16(3), obj1.c)
+	require.Equal(t, 1, constructed)
+
+	// Fill a second page and release it
+	obj2 := pool.Get()
+	obj3 := pool.Get()
+	require.Equal(t, 3, constructed)
+	pool.Put(obj3)
+	pool.Put(obj2)
+	require.Equal(t, 0, destructed)
+	pool.Put(obj1)
+	require.Equal(t, 2, destructed)
+
+	require.NoError(t, pool.Destroy())
+	require.Equal(t, 3, destructed)
+}
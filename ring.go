@@ -0,0 +1,203 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"unsafe"
+)
+
+// RingFullBehavior determines what a RingAllocator does when a Malloc call cannot fit in the free space of the ring
+type RingFullBehavior int
+
+const (
+	// RingFullFail causes Malloc to return nil when the ring is full
+	RingFullFail RingFullBehavior = iota
+	// RingFullBlock causes Malloc to wait until enough allocations have been freed for the request to fit
+	RingFullBlock
+)
+
+type ringAllocation struct {
+	start uintptr
+	end   uintptr
+}
+
+// RingAllocator is an Allocator implementation which allocates a single buffer from an inner Allocator and treats it
+// as a ring: Malloc calls are served from the head of the ring, and Free calls must be made in FIFO order, advancing
+// the tail.  Freeing any pointer other than the oldest live allocation will panic.  Allocations never straddle the end
+// of the buffer- if a request does not fit between the head and the end of the buffer, the remainder is skipped and
+// the allocation is placed at the start of the buffer instead.
+//
+// This is a good fit for streaming data into C, where buffers are produced and consumed in the same order.  Unlike the
+// other Allocator implementations in this package, RingAllocator is safe for concurrent use, so that a producer can
+// Malloc from one goroutine while a consumer Frees from another.  Whether a Malloc that does not fit fails or blocks
+// is determined by the RingFullBehavior the ring was created with.  Requests larger than the ring itself always panic.
+type RingAllocator struct {
+	inner Allocator
+
+	lock     sync.Mutex
+	freeCond *sync.Cond
+
+	region unsafe.Pointer
+	start  unsafe.Pointer
+
+	size         uintptr
+	alignment    uintptr
+	fullBehavior RingFullBehavior
+
+	allocations []ringAllocation
+}
+
+// CreateRingAllocator creates a new RingAllocator with the provided properties.
+// inner - The ring's buffer is allocated using this Allocator
+// size - The size of the ring's buffer, in bytes.  Must be a multiple of alignment.
+// alignment - All allocated pointers will be along this byte alignment.
+// fullBehavior - Whether Malloc should fail or block when the ring is full
+func CreateRingAllocator(inner Allocator, size, alignment uintptr, fullBehavior RingFullBehavior) (*RingAllocator, error) {
+	if alignment == 0 {
+		return nil, errors.New("ringallocator: alignment must be greater than 0")
+	}
+	if size%alignment != 0 {
+		return nil, errors.New("ringallocator: size must be a multiple of alignment")
+	}
+	if size > math.MaxInt-alignment {
+		return nil, fmt.Errorf("ringallocator: size overflows: %w", ErrTooLarge)
+	}
+
+	region, err := TryMalloc(inner, int(size+alignment))
+	if err != nil {
+		return nil, fmt.Errorf("ringallocator: could not allocate the ring: %w", err)
+	}
+	padding := (alignment - uintptr(region)%alignment) % alignment
+
+	ring := &RingAllocator{
+		inner: inner,
+
+		region: region,
+		start:  unsafe.Add(region, padding),
+
+		size:         size,
+		alignment:    alignment,
+		fullBehavior: fullBehavior,
+	}
+	ring.freeCond = sync.NewCond(&ring.lock)
+
+	return ring, nil
+}
+
+// findSpace returns the offset at which an allocation of the provided size can be placed, or false if the ring does
+// not currently have room for it
+func (a *RingAllocator) findSpace(size uintptr) (uintptr, bool) {
+	allocCount := len(a.allocations)
+	if allocCount == 0 {
+		return 0, true
+	}
+
+	tail := a.allocations[0].start
+	newest := a.allocations[allocCount-1]
+	head := newest.end
+
+	if newest.start < tail {
+		// The ring has already wrapped, so the only free space is between the head and the tail
+		return head, head+size <= tail
+	}
+
+	if head+size <= a.size {
+		return head, true
+	}
+
+	// Skip the space at the end of the buffer and wrap around to the start
+	return 0, size <= tail
+}
+
+func (a *RingAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if errors.Is(err, ErrOutOfMemory) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *RingAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("ringallocator: requested a negative allocation size %d", size)
+	}
+
+	alignedSize := uintptr(size)
+	if remainder := alignedSize % a.alignment; remainder != 0 {
+		alignedSize += a.alignment - remainder
+	}
+	if alignedSize == 0 {
+		alignedSize = a.alignment
+	}
+
+	if alignedSize > a.size {
+		return nil, fmt.Errorf("ringallocator: requested allocation larger than the ring: %w", ErrTooLarge)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.start == nil {
+		return nil, fmt.Errorf("ringallocator: %w", ErrDestroyed)
+	}
+
+	offset, ok := a.findSpace(alignedSize)
+	for !ok {
+		if a.fullBehavior != RingFullBlock {
+			return nil, fmt.Errorf("ringallocator: the ring is full: %w", ErrOutOfMemory)
+		}
+
+		a.freeCond.Wait()
+		if a.start == nil {
+			return nil, fmt.Errorf("ringallocator: %w", ErrDestroyed)
+		}
+		offset, ok = a.findSpace(alignedSize)
+	}
+
+	a.allocations = append(a.allocations, ringAllocation{start: offset, end: offset + alignedSize})
+	return unsafe.Add(a.start, offset), nil
+}
+
+// Free advances the tail of the ring past ptr.  It will panic if ptr is not the oldest live allocation.
+func (a *RingAllocator) Free(ptr unsafe.Pointer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.allocations) == 0 {
+		panic("ringallocator: attempted to free a pointer, but the ring is empty")
+	}
+
+	oldest := unsafe.Add(a.start, a.allocations[0].start)
+	if oldest != ptr {
+		panic(fmt.Sprintf("ringallocator: attempted to free %p, but the oldest allocation is %p- frees must be made in the order of allocation", ptr, oldest))
+	}
+
+	a.allocations[0] = ringAllocation{}
+	a.allocations = a.allocations[1:]
+
+	a.freeCond.Broadcast()
+}
+
+func (a *RingAllocator) Destroy() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.allocations) > 0 {
+		return errors.New("ringallocator: attempted to Destroy but not all allocations have been freed")
+	}
+	if a.region == nil {
+		return nil
+	}
+
+	a.inner.Free(a.region)
+	a.region = nil
+	a.start = nil
+
+	// Anyone still waiting for space will never get it now
+	a.freeCond.Broadcast()
+	return nil
+}
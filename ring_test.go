@@ -0,0 +1,146 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestRing_WrapAround(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateRingAllocator(testAlloc, 64, 8, cgoalloc.RingFullFail)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(16)
+	a2 := alloc.Malloc(16)
+	a3 := alloc.Malloc(24)
+	require.Equal(t, uintptr(16), uintptr(a2)-uintptr(a1))
+	require.Equal(t, uintptr(32), uintptr(a3)-uintptr(a1))
+
+	require.Panics(t, func() {
+		alloc.Free(a2)
+	})
+
+	// 8 bytes are left at the end, but the allocation can't straddle the end of the ring
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(16))
+
+	alloc.Free(a1)
+	a4 := alloc.Malloc(16)
+	require.Equal(t, a1, a4)
+	require.Equal(t, unsafe.Pointer(nil), alloc.Malloc(8))
+
+	alloc.Free(a2)
+	alloc.Free(a3)
+	alloc.Free(a4)
+
+	require.NoError(t, alloc.Destroy())
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{72}, allocs)
+	require.Equal(t, []int{72}, frees)
+}
+
+func TestRing_Block(t *testing.T) {
+	alloc, err := cgoalloc.CreateRingAllocator(createInnerAllocator(t), 32, 8, cgoalloc.RingFullBlock)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(16)
+	a2 := alloc.Malloc(16)
+
+	done := make(chan unsafe.Pointer)
+	go func() {
+		done <- alloc.Malloc(8)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("ringallocator: malloc did not block on a full ring")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	alloc.Free(a1)
+	a3 := <-done
+	require.Equal(t, a1, a3)
+
+	alloc.Free(a2)
+	alloc.Free(a3)
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestRing_TryMalloc(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateRingAllocator(testAlloc, 64, 8, cgoalloc.RingFullFail)
+	require.NoError(t, err)
+
+	_, err = alloc.TryMalloc(72)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	a1, err := alloc.TryMalloc(48)
+	require.NoError(t, err)
+	_, err = alloc.TryMalloc(24)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	_, err = alloc.TryMalloc(-8)
+	require.Error(t, err)
+
+	alloc.Free(a1)
+	require.NoError(t, alloc.Destroy())
+
+	_, err = alloc.TryMalloc(8)
+	require.ErrorIs(t, err, cgoalloc.ErrDestroyed)
+}
+
+func TestRing_CreateErrors(t *testing.T) {
+	failing := cgoalloctest.CreateFaultInjectingAllocator(createInnerAllocator(t), cgoalloctest.FaultOptions{FailOnCall: 1})
+	_, err := cgoalloc.CreateRingAllocator(failing, 64, 8, cgoalloc.RingFullFail)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	_, err = cgoalloc.CreateRingAllocator(failing, math.MaxInt, 1, cgoalloc.RingFullFail)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	// A second Destroy must not free the region again through an inner allocator that would panic
+	inner, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 256, 128, 8)
+	require.NoError(t, err)
+	alloc, err := cgoalloc.CreateRingAllocator(inner, 64, 8, cgoalloc.RingFullFail)
+	require.NoError(t, err)
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, inner.Destroy())
+}
+
+func TestRing_DestroyWakesWaiters(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		alloc, err := cgoalloc.CreateRingAllocator(createInnerAllocator(t), 32, 8, cgoalloc.RingFullBlock)
+		require.NoError(t, err)
+		full := alloc.Malloc(32)
+
+		type result struct {
+			ptr unsafe.Pointer
+			err error
+		}
+		done := make(chan result)
+		go func() {
+			ptr, err := alloc.TryMalloc(16)
+			done <- result{ptr: ptr, err: err}
+		}()
+
+		// Give the waiter time to block on the full ring
+		time.Sleep(time.Millisecond)
+		alloc.Free(full)
+		destroyErr := alloc.Destroy()
+		waiter := <-done
+
+		// Either the waiter got in before Destroy, which must then fail, or it must see that the ring is gone
+		if waiter.err == nil {
+			require.Error(t, destroyErr)
+			alloc.Free(waiter.ptr)
+			require.NoError(t, alloc.Destroy())
+		} else {
+			require.NoError(t, destroyErr)
+			require.ErrorIs(t, waiter.err, cgoalloc.ErrDestroyed)
+		}
+	}
+}
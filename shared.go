@@ -0,0 +1,86 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Shared is a reference-counted buffer allocated from an Allocator.  It's meant for handing the same buffer- such as a
+// marshalled struct- to several consumers, like multiple pending C calls or callbacks, without anyone having to work
+// out who the last user is.  Every consumer calls Retain before taking the buffer and Release when it's done with it,
+// and the buffer is freed through its Allocator when the last reference is released.
+//
+// Retain and Release are atomic, so they may be called from any goroutine, but the final Release frees the buffer on
+// whichever goroutine makes it- so the Allocator must tolerate Free being called from there.  Calling Retain or Release
+// after the count has reached zero panics, rather than resurrecting or double-freeing the buffer.  These checks are
+// always on, since they cost nothing beyond the atomic operation itself, and the count is left at zero when they fire.
+type Shared struct {
+	allocator Allocator
+	ptr       unsafe.Pointer
+	size      int
+
+	refs atomic.Int64
+}
+
+// CreateShared allocates a buffer of size bytes with the provided Allocator and returns it as a Shared buffer with a
+// reference count of 1
+func CreateShared(allocator Allocator, size int) (*Shared, error) {
+	ptr, err := TryMalloc(allocator, size)
+	if err != nil {
+		return nil, fmt.Errorf("shared: %w", err)
+	}
+	return ShareBuffer(allocator, ptr, size), nil
+}
+
+// ShareBuffer takes ownership of the size bytes at ptr, which must have been allocated from the provided Allocator,
+// and returns them as a Shared buffer with a reference count of 1
+func ShareBuffer(allocator Allocator, ptr unsafe.Pointer, size int) *Shared {
+	shared := &Shared{allocator: allocator, ptr: ptr, size: size}
+	shared.refs.Store(1)
+	return shared
+}
+
+// Pointer returns a pointer to the start of the buffer.  It's only valid while the caller holds a reference.
+func (s *Shared) Pointer() unsafe.Pointer { return s.ptr }
+
+// Len returns the size of the buffer, in bytes
+func (s *Shared) Len() int { return s.size }
+
+// Bytes returns a slice which aliases the buffer.  Like Pointer, it's only valid while the caller holds a reference.
+func (s *Shared) Bytes() []byte {
+	return GoBytesView(s.ptr, s.size)
+}
+
+// RefCount returns the current reference count.  By the time it returns, other goroutines may already have changed
+// it, so it's only useful for debugging and tests.
+func (s *Shared) RefCount() int {
+	return int(s.refs.Load())
+}
+
+// Retain adds a reference to the buffer, and returns the buffer for convenience.  It panics if the buffer has already
+// been freed.
+func (s *Shared) Retain() *Shared {
+	for {
+		refs := s.refs.Load()
+		if refs <= 0 {
+			panic(fmt.Sprintf("shared: attempted to retain %p after its reference count reached zero", s.ptr))
+		}
+		if s.refs.CompareAndSwap(refs, refs+1) {
+			return s
+		}
+	}
+}
+
+// Release removes a reference from the buffer, and frees it if that was the last one.  It panics if the buffer has
+// already been freed.
+func (s *Shared) Release() {
+	refs := s.refs.Add(-1)
+	if refs < 0 {
+		s.refs.Add(1)
+		panic(fmt.Sprintf("shared: attempted to release %p after its reference count reached zero", s.ptr))
+	}
+	if refs == 0 {
+		s.allocator.Free(s.ptr)
+	}
+}
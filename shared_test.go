@@ -0,0 +1,80 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestShared_RefCount(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	shared, err := cgoalloc.CreateShared(testAlloc, 16)
+	require.NoError(t, err)
+	copy(shared.Bytes(), "shared buffer")
+	require.Equal(t, 16, shared.Len())
+
+	consumer := shared.Retain()
+	require.Equal(t, 2, shared.RefCount())
+
+	shared.Release()
+	_, frees := testAlloc.Record()
+	require.Len(t, frees, 0)
+	require.Equal(t, "shared buffer", string(consumer.Bytes()[:13]))
+
+	consumer.Release()
+	_, frees = testAlloc.Record()
+	require.Equal(t, []int{16}, frees)
+
+	require.Panics(t, func() {
+		shared.Retain()
+	})
+	require.Panics(t, func() {
+		shared.Release()
+	})
+	require.Equal(t, 0, shared.RefCount())
+
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestShared_AllocationFailure(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: 1})
+
+	shared, err := cgoalloc.CreateShared(failing, 16)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Nil(t, shared)
+
+	allocs, _ := testAlloc.Record()
+	require.Empty(t, allocs)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestShared_Concurrent(t *testing.T) {
+	budget, err := cgoalloc.CreateBudgetAllocator(cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t)), cgoalloc.BudgetOptions{})
+	require.NoError(t, err)
+
+	shared := cgoalloc.ShareBuffer(budget, budget.Malloc(64), 64)
+
+	var wait sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		shared.Retain()
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				shared.Retain().Release()
+			}
+			shared.Release()
+		}()
+	}
+
+	shared.Release()
+	wait.Wait()
+
+	require.Equal(t, 0, shared.RefCount())
+	require.Equal(t, 0, budget.LiveAllocations())
+	require.NoError(t, budget.Destroy())
+}
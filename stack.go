@@ -0,0 +1,153 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// StackAllocator is an Allocator implementation which allocates a single buffer from an inner Allocator and serves
+// Malloc calls by pushing them onto the top of that buffer.  Free calls must be made in strict LIFO order- freeing any
+// pointer other than the most recent live allocation will panic.
+//
+// PushFrame and PopFrame can be used to mark a point in the stack and later free everything allocated since that point
+// in one call, which makes StackAllocator a good fit for recursive marshalling, where nested allocations are naturally
+// released in the reverse order they were made.  Malloc calls which do not fit in the remainder of the buffer will panic.
+type StackAllocator struct {
+	inner Allocator
+
+	region unsafe.Pointer
+	start  unsafe.Pointer
+
+	size      uintptr
+	alignment uintptr
+	offset    uintptr
+
+	allocations []uintptr
+	frames      []int
+}
+
+// CreateStackAllocator creates a new StackAllocator with the provided properties.
+// inner - The stack's buffer is allocated using this Allocator
+// size - The size of the stack's buffer, in bytes.  Must be a multiple of alignment.
+// alignment - All allocated pointers will be along this byte alignment.
+func CreateStackAllocator(inner Allocator, size, alignment uintptr) (*StackAllocator, error) {
+	if alignment == 0 {
+		return nil, errors.New("stackallocator: alignment must be greater than 0")
+	}
+	if size%alignment != 0 {
+		return nil, errors.New("stackallocator: size must be a multiple of alignment")
+	}
+	if size > math.MaxInt-alignment {
+		return nil, fmt.Errorf("stackallocator: size overflows: %w", ErrTooLarge)
+	}
+
+	region, err := TryMalloc(inner, int(size+alignment))
+	if err != nil {
+		return nil, fmt.Errorf("stackallocator: could not allocate the stack: %w", err)
+	}
+	padding := (alignment - uintptr(region)%alignment) % alignment
+
+	return &StackAllocator{
+		inner: inner,
+
+		region: region,
+		start:  unsafe.Add(region, padding),
+
+		size:      size,
+		alignment: alignment,
+	}, nil
+}
+
+func (a *StackAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *StackAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.start == nil {
+		return nil, fmt.Errorf("stackallocator: %w", ErrDestroyed)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("stackallocator: requested a negative allocation size %d", size)
+	}
+
+	alignedSize := uintptr(size)
+	if remainder := alignedSize % a.alignment; remainder != 0 {
+		alignedSize += a.alignment - remainder
+	}
+
+	if alignedSize > a.size {
+		return nil, fmt.Errorf("stackallocator: requested allocation larger than the stack: %w", ErrTooLarge)
+	}
+	if a.offset+alignedSize > a.size {
+		return nil, fmt.Errorf("stackallocator: requested allocation does not fit in the remainder of the stack: %w", ErrOutOfMemory)
+	}
+
+	a.allocations = append(a.allocations, a.offset)
+	ptr := unsafe.Add(a.start, a.offset)
+	a.offset += alignedSize
+	return ptr, nil
+}
+
+// Free pops the top allocation off of the stack.  It will panic if ptr is not the most recent live allocation, or if
+// the most recent live allocation was made before the current frame was pushed.
+func (a *StackAllocator) Free(ptr unsafe.Pointer) {
+	allocCount := len(a.allocations)
+	if allocCount == 0 {
+		panic("stackallocator: attempted to free a pointer, but the stack is empty")
+	}
+
+	top := a.allocations[allocCount-1]
+	if unsafe.Add(a.start, top) != ptr {
+		panic(fmt.Sprintf("stackallocator: attempted to free %p, but the top allocation is %p- frees must be made in reverse order of allocation", ptr, unsafe.Add(a.start, top)))
+	}
+
+	frameCount := len(a.frames)
+	if frameCount > 0 && a.frames[frameCount-1] == allocCount {
+		panic(fmt.Sprintf("stackallocator: attempted to free %p, which was allocated before the current frame was pushed- call PopFrame first", ptr))
+	}
+
+	a.allocations = a.allocations[:allocCount-1]
+	a.offset = top
+}
+
+// PushFrame marks the current top of the stack.  A later call to PopFrame will free everything allocated since this
+// call.  Frames may be nested.
+func (a *StackAllocator) PushFrame() {
+	a.frames = append(a.frames, len(a.allocations))
+}
+
+// PopFrame frees every allocation made since the matching PushFrame call
+func (a *StackAllocator) PopFrame() {
+	frameCount := len(a.frames)
+	if frameCount == 0 {
+		panic("stackallocator: attempted to pop a frame, but no frame has been pushed")
+	}
+
+	allocIndex := a.frames[frameCount-1]
+	a.frames = a.frames[:frameCount-1]
+
+	if allocIndex < len(a.allocations) {
+		a.offset = a.allocations[allocIndex]
+		a.allocations = a.allocations[:allocIndex]
+	}
+}
+
+func (a *StackAllocator) Destroy() error {
+	if len(a.allocations) > 0 {
+		return errors.New("stackallocator: attempted to Destroy but not all allocations have been freed")
+	}
+	if a.region == nil {
+		return nil
+	}
+
+	a.inner.Free(a.region)
+	a.region = nil
+	a.start = nil
+	return nil
+}
@@ -0,0 +1,116 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestStack_LIFO(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateStackAllocator(testAlloc, 64, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(8)
+	a2 := alloc.Malloc(5)
+	a3 := alloc.Malloc(16)
+	require.Equal(t, uintptr(8), uintptr(a2)-uintptr(a1))
+	require.Equal(t, uintptr(16), uintptr(a3)-uintptr(a1))
+
+	require.Panics(t, func() {
+		alloc.Free(a2)
+	})
+
+	alloc.Free(a3)
+	alloc.Free(a2)
+	require.Equal(t, a2, alloc.Malloc(40))
+	require.Panics(t, func() {
+		_ = alloc.Malloc(17)
+	})
+
+	require.Error(t, alloc.Destroy())
+	alloc.Free(a2)
+	alloc.Free(a1)
+
+	require.NoError(t, alloc.Destroy())
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{72}, allocs)
+	require.Equal(t, []int{72}, frees)
+}
+
+func TestStack_Frames(t *testing.T) {
+	alloc, err := cgoalloc.CreateStackAllocator(createInnerAllocator(t), 64, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(8)
+
+	alloc.PushFrame()
+	a2 := alloc.Malloc(8)
+	_ = alloc.Malloc(8)
+
+	alloc.PushFrame()
+	alloc.PopFrame()
+
+	alloc.PushFrame()
+	_ = alloc.Malloc(8)
+	alloc.PopFrame()
+
+	alloc.PopFrame()
+	require.Equal(t, a2, alloc.Malloc(8))
+
+	alloc.PushFrame()
+	require.Panics(t, func() {
+		alloc.Free(a2)
+	})
+	alloc.PopFrame()
+
+	alloc.Free(a2)
+	alloc.Free(a1)
+	require.Panics(t, func() {
+		alloc.PopFrame()
+	})
+	require.NoError(t, alloc.Destroy())
+}
+
+func TestStack_TryMalloc(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateStackAllocator(testAlloc, 64, 8)
+	require.NoError(t, err)
+
+	_, err = alloc.TryMalloc(72)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	a1, err := alloc.TryMalloc(48)
+	require.NoError(t, err)
+	_, err = alloc.TryMalloc(24)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	_, err = alloc.TryMalloc(-8)
+	require.Error(t, err)
+
+	alloc.Free(a1)
+	require.NoError(t, alloc.Destroy())
+
+	_, err = alloc.TryMalloc(8)
+	require.ErrorIs(t, err, cgoalloc.ErrDestroyed)
+}
+
+func TestStack_CreateErrors(t *testing.T) {
+	failing := cgoalloctest.CreateFaultInjectingAllocator(createInnerAllocator(t), cgoalloctest.FaultOptions{FailOnCall: 1})
+	_, err := cgoalloc.CreateStackAllocator(failing, 64, 8)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	_, err = cgoalloc.CreateStackAllocator(failing, math.MaxInt, 1)
+	require.ErrorIs(t, err, cgoalloc.ErrTooLarge)
+
+	// A second Destroy must not free the region again through an inner allocator that would panic
+	inner, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 256, 128, 8)
+	require.NoError(t, err)
+	alloc, err := cgoalloc.CreateStackAllocator(inner, 64, 8)
+	require.NoError(t, err)
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, inner.Destroy())
+}
@@ -0,0 +1,313 @@
+package cgoalloc
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	tlsfSecondLevelLog2  = 4
+	tlsfSecondLevelCount = 1 << tlsfSecondLevelLog2
+	tlsfFirstLevelCount  = 64
+)
+
+type tlsfPage struct {
+	region unsafe.Pointer
+	start  unsafe.Pointer
+	size   uintptr
+}
+
+type tlsfBlock struct {
+	page   *tlsfPage
+	offset uintptr
+	size   uintptr
+	free   bool
+
+	prevPhys *tlsfBlock
+	nextPhys *tlsfBlock
+
+	prevFree *tlsfBlock
+	nextFree *tlsfBlock
+}
+
+// TLSFAllocator is an Allocator implementation which uses the Two-Level Segregated Fit algorithm to serve Malloc calls
+// of any size from large pages allocated from an inner Allocator.  Free blocks are kept in a two-level table of
+// segregated lists indexed by size, so both Malloc and Free run in O(1) time: Malloc finds the smallest list that is
+// guaranteed to satisfy the request, splitting off whatever is left over, and Free coalesces the block with any free
+// physical neighbors before returning it to the table.
+//
+// Requests larger than the page size get a dedicated page of their own.  Whenever a Free leaves a page completely
+// unused, the page is freed, unless it is the last regular-sized page the allocator has.  Unlike the
+// FixedBlockAllocator, TLSFAllocator accepts mixed sizes without wasting a full block on small requests, at the cost
+// of some per-allocation bookkeeping.
+type TLSFAllocator struct {
+	inner Allocator
+
+	pageSize         uintptr
+	alignment        uintptr
+	firstLevelShift  int
+	smallestListSize uintptr
+
+	firstLevelBitmap  uint64
+	secondLevelBitmap [tlsfFirstLevelCount]uint64
+	freeLists         [tlsfFirstLevelCount][tlsfSecondLevelCount]*tlsfBlock
+
+	regularPages int
+	pages        map[*tlsfPage]struct{}
+	usedBlocks   map[uintptr]*tlsfBlock
+
+	destroyed bool
+}
+
+// CreateTLSFAllocator creates a new TLSFAllocator with the provided properties.
+// inner - Pages are created using this Allocator
+// pageSize - The size of allocated pages, in bytes.  Must be a multiple of alignment.
+// alignment - All allocated pointers will be along this byte alignment.  Must be a power of two.
+func CreateTLSFAllocator(inner Allocator, pageSize, alignment uintptr) (*TLSFAllocator, error) {
+	if alignment == 0 || alignment&(alignment-1) != 0 {
+		return nil, errors.New("tlsfallocator: alignment must be a power of two")
+	}
+	if pageSize == 0 || pageSize%alignment != 0 {
+		return nil, errors.New("tlsfallocator: pagesize must be a nonzero multiple of alignment")
+	}
+
+	firstLevelShift := tlsfSecondLevelLog2 + bits.TrailingZeros64(uint64(alignment))
+
+	return &TLSFAllocator{
+		inner: inner,
+
+		pageSize:         pageSize,
+		alignment:        alignment,
+		firstLevelShift:  firstLevelShift,
+		smallestListSize: 1 << firstLevelShift,
+
+		pages:      make(map[*tlsfPage]struct{}),
+		usedBlocks: make(map[uintptr]*tlsfBlock),
+	}, nil
+}
+
+// mapping returns the indices of the free list that blocks of the provided size are stored in
+func (a *TLSFAllocator) mapping(size uintptr) (firstLevel, secondLevel int) {
+	if size < a.smallestListSize {
+		return 0, int(size / a.alignment)
+	}
+
+	highBit := bits.Len64(uint64(size)) - 1
+	secondLevel = int(size>>(highBit-tlsfSecondLevelLog2)) ^ tlsfSecondLevelCount
+	firstLevel = highBit - a.firstLevelShift + 1
+	return firstLevel, secondLevel
+}
+
+// findSuitableBlock returns a free block which is guaranteed to be at least the provided size, or nil if there isn't one
+func (a *TLSFAllocator) findSuitableBlock(size uintptr) *tlsfBlock {
+	if size >= a.smallestListSize {
+		// Round up to the next list, so that every block in the list we land on is large enough
+		size += (1 << (bits.Len64(uint64(size)) - 1 - tlsfSecondLevelLog2)) - 1
+	}
+	firstLevel, secondLevel := a.mapping(size)
+
+	secondLevelMap := a.secondLevelBitmap[firstLevel] & (^uint64(0) << secondLevel)
+	if secondLevelMap == 0 {
+		firstLevelMap := a.firstLevelBitmap & (^uint64(0) << (firstLevel + 1))
+		if firstLevelMap == 0 {
+			return nil
+		}
+
+		firstLevel = bits.TrailingZeros64(firstLevelMap)
+		secondLevelMap = a.secondLevelBitmap[firstLevel]
+	}
+
+	secondLevel = bits.TrailingZeros64(secondLevelMap)
+	return a.freeLists[firstLevel][secondLevel]
+}
+
+func (a *TLSFAllocator) insertFreeBlock(block *tlsfBlock) {
+	firstLevel, secondLevel := a.mapping(block.size)
+
+	head := a.freeLists[firstLevel][secondLevel]
+	block.free = true
+	block.prevFree = nil
+	block.nextFree = head
+	if head != nil {
+		head.prevFree = block
+	}
+
+	a.freeLists[firstLevel][secondLevel] = block
+	a.firstLevelBitmap |= 1 << firstLevel
+	a.secondLevelBitmap[firstLevel] |= 1 << secondLevel
+}
+
+func (a *TLSFAllocator) removeFreeBlock(block *tlsfBlock) {
+	firstLevel, secondLevel := a.mapping(block.size)
+
+	if block.prevFree != nil {
+		block.prevFree.nextFree = block.nextFree
+	}
+	if block.nextFree != nil {
+		block.nextFree.prevFree = block.prevFree
+	}
+
+	if a.freeLists[firstLevel][secondLevel] == block {
+		a.freeLists[firstLevel][secondLevel] = block.nextFree
+		if block.nextFree == nil {
+			a.secondLevelBitmap[firstLevel] &^= 1 << secondLevel
+			if a.secondLevelBitmap[firstLevel] == 0 {
+				a.firstLevelBitmap &^= 1 << firstLevel
+			}
+		}
+	}
+
+	block.free = false
+	block.prevFree = nil
+	block.nextFree = nil
+}
+
+// allocatePage creates a new page large enough to hold size bytes, and returns the single block spanning it.  The
+// block is not added to the free lists.
+func (a *TLSFAllocator) allocatePage(size uintptr) (*tlsfBlock, error) {
+	pageSize := a.pageSize
+	if size > pageSize {
+		pageSize = size
+	}
+
+	region, err := TryMalloc(a.inner, int(pageSize+a.alignment))
+	if err != nil {
+		return nil, err
+	}
+	if pageSize == a.pageSize {
+		a.regularPages++
+	}
+	padding := (a.alignment - uintptr(region)%a.alignment) % a.alignment
+
+	page := &tlsfPage{region: region, start: unsafe.Add(region, padding), size: pageSize}
+	a.pages[page] = struct{}{}
+
+	return &tlsfBlock{page: page, size: pageSize}, nil
+}
+
+func (a *TLSFAllocator) deallocatePage(page *tlsfPage) {
+	if page.size == a.pageSize {
+		a.regularPages--
+	}
+
+	delete(a.pages, page)
+	a.inner.Free(page.region)
+}
+
+func (a *TLSFAllocator) Malloc(size int) unsafe.Pointer {
+	ptr, err := a.TryMalloc(size)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+func (a *TLSFAllocator) TryMalloc(size int) (unsafe.Pointer, error) {
+	if a.destroyed {
+		return nil, fmt.Errorf("tlsfallocator: %w", ErrDestroyed)
+	}
+
+	alignedSize := uintptr(size)
+	if remainder := alignedSize % a.alignment; remainder != 0 {
+		alignedSize += a.alignment - remainder
+	}
+	if alignedSize == 0 {
+		alignedSize = a.alignment
+	}
+
+	block := a.findSuitableBlock(alignedSize)
+	if block == nil {
+		var err error
+		block, err = a.allocatePage(alignedSize)
+		if err != nil {
+			return nil, fmt.Errorf("tlsfallocator: failed to allocate a page: %w", err)
+		}
+	} else {
+		a.removeFreeBlock(block)
+	}
+
+	// Split off whatever is left over
+	if block.size-alignedSize >= a.alignment {
+		remainder := &tlsfBlock{
+			page:     block.page,
+			offset:   block.offset + alignedSize,
+			size:     block.size - alignedSize,
+			prevPhys: block,
+			nextPhys: block.nextPhys,
+		}
+		if block.nextPhys != nil {
+			block.nextPhys.prevPhys = remainder
+		}
+		block.nextPhys = remainder
+		block.size = alignedSize
+
+		a.insertFreeBlock(remainder)
+	}
+
+	ptr := unsafe.Add(block.page.start, block.offset)
+	a.usedBlocks[uintptr(ptr)] = block
+	return ptr, nil
+}
+
+func (a *TLSFAllocator) Free(ptr unsafe.Pointer) {
+	block, ok := a.usedBlocks[uintptr(ptr)]
+	if !ok {
+		panic("tlsfallocator: attempted to free a pointer which had not been allocated with this allocator")
+	}
+	delete(a.usedBlocks, uintptr(ptr))
+
+	// Coalesce with free neighbors
+	if prev := block.prevPhys; prev != nil && prev.free {
+		a.removeFreeBlock(prev)
+		prev.size += block.size
+		prev.nextPhys = block.nextPhys
+		if block.nextPhys != nil {
+			block.nextPhys.prevPhys = prev
+		}
+		block = prev
+	}
+
+	if next := block.nextPhys; next != nil && next.free {
+		a.removeFreeBlock(next)
+		block.size += next.size
+		block.nextPhys = next.nextPhys
+		if next.nextPhys != nil {
+			next.nextPhys.prevPhys = block
+		}
+	}
+
+	if block.prevPhys == nil && block.nextPhys == nil {
+		// The whole page is free- hang onto it only if it's the last regular page we have
+		if block.page.size != a.pageSize || a.regularPages > 1 {
+			a.deallocatePage(block.page)
+			return
+		}
+	}
+
+	a.insertFreeBlock(block)
+}
+
+func (a *TLSFAllocator) Destroy() error {
+	if a.destroyed {
+		return nil
+	}
+
+	if len(a.usedBlocks) > 0 {
+		return errors.New("tlsfallocator: attempted to Destroy, but not all allocations had been freed")
+	}
+
+	for page := range a.pages {
+		a.inner.Free(page.region)
+	}
+	a.pages = make(map[*tlsfPage]struct{})
+
+	a.firstLevelBitmap = 0
+	a.secondLevelBitmap = [tlsfFirstLevelCount]uint64{}
+	a.freeLists = [tlsfFirstLevelCount][tlsfSecondLevelCount]*tlsfBlock{}
+	a.regularPages = 0
+	a.destroyed = true
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sort"
+	"testing"
+	"unsafe"
+)
+
+func TestTLSF_SplitAndCoalesce(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateTLSFAllocator(testAlloc, 256, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(8)
+	a2 := alloc.Malloc(20)
+	a3 := alloc.Malloc(100)
+	require.Equal(t, uintptr(8), uintptr(a2)-uintptr(a1))
+	require.Equal(t, uintptr(32), uintptr(a3)-uintptr(a1))
+
+	alloc.Free(a1)
+	alloc.Free(a2)
+
+	// a1 and a2 have been merged back into a single 32 byte block
+	require.Equal(t, a1, alloc.Malloc(32))
+	alloc.Free(a1)
+	alloc.Free(a3)
+
+	// The whole page should be available again
+	a4 := alloc.Malloc(256)
+	require.Equal(t, a1, a4)
+	alloc.Free(a4)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{264}, allocs)
+	require.Len(t, frees, 0)
+
+	require.NoError(t, alloc.Destroy())
+	allocs, frees = testAlloc.Record()
+	require.Equal(t, []int{264}, frees)
+}
+
+func TestTLSF_ReleasePages(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateTLSFAllocator(testAlloc, 64, 8)
+	require.NoError(t, err)
+
+	a1 := alloc.Malloc(64)
+	a2 := alloc.Malloc(64)
+	a3 := alloc.Malloc(1000)
+
+	alloc.Free(a3)
+	alloc.Free(a1)
+	alloc.Free(a2)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{72, 72, 1008}, allocs)
+	require.ElementsMatch(t, []int{72, 1008}, frees)
+
+	a1 = alloc.Malloc(16)
+	require.Error(t, alloc.Destroy())
+	alloc.Free(a1)
+	require.NoError(t, alloc.Destroy())
+
+	allocs, frees = testAlloc.Record()
+	require.Equal(t, []int{72, 72, 1008}, allocs)
+	require.ElementsMatch(t, []int{72, 72, 1008}, frees)
+}
+
+func TestTLSF_RandomSizes(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	alloc, err := cgoalloc.CreateTLSFAllocator(testAlloc, 4096, 16)
+	require.NoError(t, err)
+
+	type allocation struct {
+		start uintptr
+		size  int
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	live := map[unsafe.Pointer]allocation{}
+	for i := 0; i < 5000; i++ {
+		if len(live) > 0 && rng.Intn(3) == 0 {
+			for ptr := range live {
+				alloc.Free(ptr)
+				delete(live, ptr)
+				break
+			}
+			continue
+		}
+
+		size := 1 + rng.Intn(3000)
+		ptr := alloc.Malloc(size)
+		require.Zero(t, uintptr(ptr)%16)
+		live[ptr] = allocation{start: uintptr(ptr), size: size}
+	}
+
+	sorted := make([]allocation, 0, len(live))
+	for _, a := range live {
+		sorted = append(sorted, a)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	for i := 1; i < len(sorted); i++ {
+		require.True(t, sorted[i-1].start+uintptr(sorted[i-1].size) <= sorted[i].start)
+	}
+
+	for ptr := range live {
+		alloc.Free(ptr)
+	}
+	require.NoError(t, alloc.Destroy())
+	require.NoError(t, testAlloc.Destroy())
+}
@@ -0,0 +1,116 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// New allocates a zeroed T using the provided Allocator, and returns a pointer to it, or nil if the allocation fails.
+// The memory must be freed with Free.  If T is zero-sized, New returns an ordinary Go pointer, since there's nothing to
+// allocate.  Because the GC can't see into C memory, T must not contain any Go pointers.
+func New[T any](allocator Allocator) *T {
+	obj, _ := NewE[T](allocator)
+	return obj
+}
+
+// NewE is equivalent to New, but returns an error if the allocation fails
+func NewE[T any](allocator Allocator) (*T, error) {
+	var zero T
+	if unsafe.Sizeof(zero) == 0 {
+		return new(T), nil
+	}
+
+	ptr, err := CallocE(allocator, 1, int(unsafe.Sizeof(zero)))
+	return (*T)(ptr), err
+}
+
+// Free frees a T that was allocated with New
+func Free[T any](allocator Allocator, obj *T) {
+	var zero T
+	if unsafe.Sizeof(zero) == 0 {
+		return
+	}
+
+	allocator.Free(unsafe.Pointer(obj))
+}
+
+// MakeSlice allocates n zeroed T values in a single buffer using the provided Allocator, and returns a Go slice over
+// that buffer.  The slice can be handed to C with &slice[0], and must be freed with FreeSlice.  If n is 0, MakeSlice
+// returns nil without allocating anything, and if T is zero-sized, it returns an ordinary Go slice, since there's
+// nothing to allocate.  MakeSlice panics if n is negative.  Because the GC can't see into C memory, T must not contain
+// any Go pointers.  It panics if the allocation fails- use MakeSliceE to handle that instead.
+func MakeSlice[T any](allocator Allocator, n int) []T {
+	slice, err := MakeSliceE[T](allocator, n)
+	if err != nil {
+		panic(err)
+	}
+	return slice
+}
+
+// MakeSliceE is equivalent to MakeSlice, but returns an error instead of panicking if the allocation fails
+func MakeSliceE[T any](allocator Allocator, n int) ([]T, error) {
+	if n < 0 {
+		panic("cgoalloc: MakeSlice called with a negative length")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	var zero T
+	if unsafe.Sizeof(zero) == 0 {
+		return make([]T, n), nil
+	}
+
+	ptr, err := CallocE(allocator, n, int(unsafe.Sizeof(zero)))
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*T)(ptr), n), nil
+}
+
+// FreeSlice frees a slice that was allocated with MakeSlice.  The slice may have been resliced, as long as it still
+// starts at the first element of the allocated buffer.
+func FreeSlice[T any](allocator Allocator, slice []T) {
+	var zero T
+	if cap(slice) == 0 || unsafe.Sizeof(zero) == 0 {
+		return
+	}
+
+	allocator.Free(unsafe.Pointer(&slice[:1][0]))
+}
+
+// CopySlice is the generic equivalent of CBytes: it allocates a buffer using the provided Allocator, copies the
+// contents of slice into it, and returns a pointer to the buffer.  The buffer must be freed with the Allocator's Free
+// method.  A slice that occupies no memory, because it's empty or T is zero-sized, still gets a one-byte buffer, so
+// that the result can always be freed.  Because the GC can't see into C memory, T must not contain any Go pointers.  It
+// panics if the allocation fails- use CopySliceE to handle that instead.
+func CopySlice[T any](allocator Allocator, slice []T) unsafe.Pointer {
+	ptr, err := CopySliceE(allocator, slice)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+// CopySliceE is equivalent to CopySlice, but returns an error instead of panicking if the allocation fails
+func CopySliceE[T any](allocator Allocator, slice []T) (unsafe.Pointer, error) {
+	var zero T
+	elementSize := int(unsafe.Sizeof(zero))
+	if elementSize > 0 && len(slice) > math.MaxInt/elementSize {
+		panic(fmt.Sprintf("cgoalloc: CopySlice of %d elements of %d bytes overflows", len(slice), elementSize))
+	}
+
+	size := len(slice) * elementSize
+	if size == 0 {
+		return TryMalloc(allocator, 1)
+	}
+
+	ptr, err := TryMalloc(allocator, size)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(unsafe.Slice((*T)(ptr), len(slice)), slice)
+	return ptr, nil
+}
@@ -0,0 +1,113 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func TestTyped_NewAndSlices(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+
+	obj := cgoalloc.New[poolTestStruct](testAlloc)
+	require.Equal(t, poolTestStruct{}, *obj)
+
+	slice := cgoalloc.MakeSlice[uint32](testAlloc, 5)
+	require.Equal(t, []uint32{0, 0, 0, 0, 0}, slice)
+	slice[4] = 7
+	require.Nil(t, cgoalloc.MakeSlice[uint32](testAlloc, 0))
+	require.Panics(t, func() {
+		_ = cgoalloc.MakeSlice[uint32](testAlloc, -1)
+	})
+
+	empty := cgoalloc.MakeSlice[struct{}](testAlloc, 3)
+	require.Len(t, empty, 3)
+	cgoalloc.FreeSlice(testAlloc, empty)
+
+	emptyObj := cgoalloc.New[struct{}](testAlloc)
+	require.NotNil(t, emptyObj)
+	cgoalloc.Free(testAlloc, emptyObj)
+
+	emptyCopy := cgoalloc.CopySlice(testAlloc, []struct{}{{}, {}})
+	require.NotEqual(t, unsafe.Pointer(nil), emptyCopy)
+	testAlloc.Free(emptyCopy)
+
+	copied := cgoalloc.CopySlice(testAlloc, slice[3:])
+	require.Equal(t, []uint32{0, 7}, unsafe.Slice((*uint32)(copied), 2))
+
+	bytes := cgoalloc.CBytes(testAlloc, []byte{1, 2, 3})
+	require.Equal(t, []byte{1, 2, 3}, unsafe.Slice((*byte)(bytes), 3))
+
+	cgoalloc.Free(testAlloc, obj)
+	cgoalloc.FreeSlice(testAlloc, slice[:2])
+	cgoalloc.FreeSlice[uint32](testAlloc, nil)
+	testAlloc.Free(copied)
+	testAlloc.Free(bytes)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{24, 20, 1, 8, 3}, allocs)
+	require.ElementsMatch(t, []int{24, 20, 1, 8, 3}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestTyped_AllocationFailures(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{ByteBudget: 8})
+
+	obj, err := cgoalloc.NewE[poolTestStruct](failing)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Nil(t, obj)
+	require.Nil(t, cgoalloc.New[poolTestStruct](failing))
+
+	_, err = cgoalloc.MakeSliceE[uint32](failing, 5)
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Panics(t, func() {
+		_ = cgoalloc.MakeSlice[uint32](failing, 5)
+	})
+
+	_, err = cgoalloc.CopySliceE(failing, []uint32{1, 2, 3})
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	require.Panics(t, func() {
+		_ = cgoalloc.CopySlice(failing, []uint32{1, 2, 3})
+	})
+
+	copied, err := cgoalloc.CopySliceE(failing, []uint32{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 2}, unsafe.Slice((*uint32)(copied), 2))
+	failing.Free(copied)
+
+	_, err = cgoalloc.CStringPointerE(failing, "too long for the budget")
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+	str, err := cgoalloc.CStringPointerE(failing, "abc")
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc\x00"), unsafe.Slice((*byte)(str), 4))
+	failing.Free(str)
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{8, 4}, allocs)
+	require.Equal(t, []int{8, 4}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestCalloc_ZeroesReusedMemory(t *testing.T) {
+	fba, err := cgoalloc.CreateFixedBlockAllocator(createInnerAllocator(t), 64, 16, 8)
+	require.NoError(t, err)
+
+	dirty := fba.Malloc(16)
+	buffer := unsafe.Slice((*byte)(dirty), 16)
+	for i := range buffer {
+		buffer[i] = 0xff
+	}
+	fba.Free(dirty)
+
+	ptr := cgoalloc.Calloc(fba, 4, 4)
+	require.Equal(t, make([]byte, 16), unsafe.Slice((*byte)(ptr), 16))
+	require.Panics(t, func() {
+		_ = cgoalloc.Calloc(fba, -1, 4)
+	})
+
+	fba.Free(ptr)
+	require.NoError(t, fba.Destroy())
+}
@@ -0,0 +1,183 @@
+package cgoalloc
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// GrowthPolicy decides how far a Vector grows when it runs out of room.  It receives the vector's current capacity and
+// the capacity it requires, and returns the new capacity, which must be at least required.
+type GrowthPolicy func(capacity, required int) int
+
+// DoublingGrowth is the default GrowthPolicy: it doubles the vector's capacity, or grows it to the required capacity,
+// whichever is larger
+func DoublingGrowth(capacity, required int) int {
+	newCapacity := capacity * 2
+	if newCapacity < required {
+		newCapacity = required
+	}
+	return newCapacity
+}
+
+// Vector is a growable, contiguous array of T values which lives in C memory allocated through an Allocator.  Because
+// its contents live in C memory, Data can be handed to C and held onto across calls- though only until the next call
+// that adds elements or reserves capacity, since growing the vector can move it.  Release must be called to free the
+// vector's memory once it's no longer needed.
+//
+// If T is zero-sized, the vector never allocates, since its elements take up no memory.  Because the GC can't see into
+// C memory, T must not contain any Go pointers.
+type Vector[T any] struct {
+	allocator Allocator
+	growth    GrowthPolicy
+
+	data     unsafe.Pointer
+	length   int
+	capacity int
+}
+
+// CreateVector creates a new, empty Vector which allocates its memory from the provided Allocator.  If capacity is
+// greater than 0, room for that many elements is allocated up front.
+func CreateVector[T any](allocator Allocator, capacity int) *Vector[T] {
+	vector := &Vector[T]{
+		allocator: allocator,
+		growth:    DoublingGrowth,
+	}
+	vector.Reserve(capacity)
+	return vector
+}
+
+// SetGrowthPolicy changes the policy this vector uses to decide how far to grow when it runs out of room
+func (v *Vector[T]) SetGrowthPolicy(policy GrowthPolicy) {
+	v.growth = policy
+}
+
+func (v *Vector[T]) elementSize() int {
+	var zero T
+	return int(unsafe.Sizeof(zero))
+}
+
+// Len returns the number of elements in the vector
+func (v *Vector[T]) Len() int { return v.length }
+
+// Cap returns the number of elements the vector can hold before it will need to grow
+func (v *Vector[T]) Cap() int { return v.capacity }
+
+// Data returns a pointer to the first element of the vector.  It is nil if the vector has never allocated.
+func (v *Vector[T]) Data() unsafe.Pointer { return v.data }
+
+// Slice returns a Go slice which aliases the vector's elements.  The slice is only valid until the next call that
+// adds elements, reserves capacity or releases the vector.
+func (v *Vector[T]) Slice() []T {
+	if v.data == nil {
+		return nil
+	}
+
+	return unsafe.Slice((*T)(v.data), v.length)
+}
+
+// Reserve ensures the vector can hold at least capacity elements without growing.  Unlike growth triggered by adding
+// elements, Reserve grows to exactly the requested capacity, ignoring the growth policy.  It panics if the allocation
+// fails, in which case the vector is left as it was.
+func (v *Vector[T]) Reserve(capacity int) {
+	if capacity <= v.capacity {
+		return
+	}
+
+	elementSize := v.elementSize()
+	if elementSize == 0 {
+		// Every element lives at the same address, so any non-nil pointer will do
+		if v.data == nil {
+			v.data = unsafe.Pointer(new(T))
+		}
+		v.capacity = capacity
+		return
+	}
+	if capacity > math.MaxInt/elementSize {
+		panic(fmt.Sprintf("vector: a capacity of %d elements of %d bytes overflows", capacity, elementSize))
+	}
+
+	v.data = Realloc(v.allocator, v.data, v.capacity*elementSize, capacity*elementSize)
+	v.capacity = capacity
+}
+
+func (v *Vector[T]) grow(required int) {
+	if required <= v.capacity {
+		return
+	}
+
+	newCapacity := v.growth(v.capacity, required)
+	if newCapacity < required {
+		panic("vector: growth policy returned a capacity smaller than the required capacity")
+	}
+	v.Reserve(newCapacity)
+}
+
+// overlaps returns true if values points into the vector's memory, such as a slice returned by Slice
+func (v *Vector[T]) overlaps(values []T) bool {
+	if v.data == nil || len(values) == 0 || v.elementSize() == 0 {
+		return false
+	}
+
+	start := uintptr(unsafe.Pointer(&values[0]))
+	end := start + uintptr(len(values)*v.elementSize())
+	dataStart := uintptr(v.data)
+	dataEnd := dataStart + uintptr(v.capacity*v.elementSize())
+	return start < dataEnd && dataStart < end
+}
+
+// Append adds values to the end of the vector.  values may alias the vector itself.
+func (v *Vector[T]) Append(values ...T) {
+	if v.overlaps(values) {
+		// Growing can free the memory values points to
+		values = append([]T(nil), values...)
+	}
+
+	v.grow(v.length + len(values))
+	v.length += copy(unsafe.Slice((*T)(v.data), v.capacity)[v.length:], values)
+}
+
+// Insert adds values to the vector at index, moving the elements after it back.  values may alias the vector itself.
+func (v *Vector[T]) Insert(index int, values ...T) {
+	if index < 0 || index > v.length {
+		panic("vector: insert index out of range")
+	}
+	if v.overlaps(values) {
+		// Growing can free the memory values points to, and moving the elements back can overwrite it
+		values = append([]T(nil), values...)
+	}
+
+	v.grow(v.length + len(values))
+	elements := unsafe.Slice((*T)(v.data), v.capacity)
+	copy(elements[index+len(values):], elements[index:v.length])
+	copy(elements[index:], values)
+	v.length += len(values)
+}
+
+// Remove removes the element at index, moving the elements after it forward
+func (v *Vector[T]) Remove(index int) {
+	if index < 0 || index >= v.length {
+		panic("vector: remove index out of range")
+	}
+
+	elements := v.Slice()
+	copy(elements[index:], elements[index+1:])
+	v.length--
+}
+
+// Clear removes all elements from the vector without freeing its memory
+func (v *Vector[T]) Clear() {
+	v.length = 0
+}
+
+// Release frees the vector's memory.  The vector is left empty, and can be added to again, in which case it will
+// allocate new memory.
+func (v *Vector[T]) Release() {
+	if v.data != nil && v.elementSize() > 0 {
+		v.allocator.Free(v.data)
+	}
+
+	v.data = nil
+	v.length = 0
+	v.capacity = 0
+}
@@ -0,0 +1,104 @@
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestVector_AppendInsertRemove(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	vector := cgoalloc.CreateVector[uint32](testAlloc, 2)
+
+	vector.Append(1, 2)
+	vector.Append(5)
+	require.Equal(t, 4, vector.Cap())
+	vector.Insert(2, 3, 4)
+	vector.Insert(0, 0)
+	require.Equal(t, []uint32{0, 1, 2, 3, 4, 5}, vector.Slice())
+	require.Equal(t, 8, vector.Cap())
+
+	vector.Remove(5)
+	vector.Remove(0)
+	require.Equal(t, []uint32{1, 2, 3, 4}, vector.Slice())
+	require.Equal(t, uint32(1), *(*uint32)(vector.Data()))
+
+	vector.Release()
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{8, 16, 32}, allocs)
+	require.Equal(t, []int{8, 16, 32}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestVector_GrowthPolicy(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	vector := cgoalloc.CreateVector[uint64](testAlloc, 0)
+	vector.SetGrowthPolicy(func(capacity, required int) int {
+		return required + 3
+	})
+
+	vector.Append(1)
+	require.Equal(t, 4, vector.Cap())
+	vector.Reserve(10)
+	require.Equal(t, 10, vector.Cap())
+	require.Equal(t, []uint64{1}, vector.Slice())
+
+	vector.SetGrowthPolicy(func(capacity, required int) int {
+		return capacity
+	})
+	require.Panics(t, func() {
+		vector.Append(make([]uint64, 10)...)
+	})
+
+	vector.Release()
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{32, 80}, allocs)
+	require.Equal(t, []int{32, 80}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestVector_AppendSelf(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	vector := cgoalloc.CreateVector[uint32](testAlloc, 3)
+
+	vector.Append(1, 2, 3)
+	vector.Append(vector.Slice()...)
+	require.Equal(t, []uint32{1, 2, 3, 1, 2, 3}, vector.Slice())
+
+	vector.Insert(1, vector.Slice()[3:5]...)
+	require.Equal(t, []uint32{1, 1, 2, 2, 3, 1, 2, 3}, vector.Slice())
+
+	vector.Release()
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestVector_ZeroSized(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	vector := cgoalloc.CreateVector[struct{}](testAlloc, 0)
+
+	vector.Append(struct{}{}, struct{}{})
+	vector.Insert(1, struct{}{})
+	require.Equal(t, 3, vector.Len())
+	require.Len(t, vector.Slice(), 3)
+
+	vector.Remove(0)
+	require.Len(t, vector.Slice(), 2)
+	vector.Release()
+
+	allocs, _ := testAlloc.Record()
+	require.Empty(t, allocs)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestVector_ReserveOverflow(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, createInnerAllocator(t))
+	vector := cgoalloc.CreateVector[uint64](testAlloc, 0)
+
+	require.Panics(t, func() {
+		vector.Reserve(math.MaxInt / 4)
+	})
+	require.Equal(t, 0, vector.Cap())
+	require.NoError(t, testAlloc.Destroy())
+}
@@ -0,0 +1,45 @@
+//go:build cgo
+
+package cgoalloc
+
+/*
+#include <wchar.h>
+*/
+import "C"
+import "unsafe"
+
+// CWCharString converts str into a NUL-terminated wchar_t string using the provided Allocator.  wchar_t strings are
+// UTF-32 on platforms with a 4-byte wchar_t, such as Linux and macOS, and UTF-16 on platforms with a 2-byte wchar_t,
+// such as Windows.  The result must be freed with the Allocator's Free method.  It panics if the allocation fails- use
+// CWCharStringE to handle that instead.
+func CWCharString(allocator Allocator, str string) *C.wchar_t {
+	ptr, err := CWCharStringE(allocator, str)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+// CWCharStringE is equivalent to CWCharString, but returns an error instead of panicking if the allocation fails
+func CWCharStringE(allocator Allocator, str string) (*C.wchar_t, error) {
+	if unsafe.Sizeof(C.wchar_t(0)) == 2 {
+		ptr, err := CWStringE(allocator, str)
+		return (*C.wchar_t)(unsafe.Pointer(ptr)), err
+	}
+
+	ptr, err := cUTF32String(allocator, str)
+	return (*C.wchar_t)(ptr), err
+}
+
+// GoWCharString decodes the NUL-terminated wchar_t string str into a Go string.  A nil str produces an empty string.
+func GoWCharString(str *C.wchar_t) string {
+	if str == nil {
+		return ""
+	}
+
+	if unsafe.Sizeof(C.wchar_t(0)) == 2 {
+		return GoWString((*uint16)(unsafe.Pointer(str)))
+	}
+
+	return goUTF32String(unsafe.Pointer(str))
+}
@@ -0,0 +1,100 @@
+package cgoalloc
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// CWString converts str into a NUL-terminated UTF-16 string, such as the wide strings used by Windows APIs, using the
+// provided Allocator.  Invalid UTF-8 sequences are replaced with U+FFFD.  The result must be freed with the
+// Allocator's Free method.  It panics if the allocation fails- use CWStringE to handle that instead.
+func CWString(allocator Allocator, str string) *uint16 {
+	ptr, err := CWStringE(allocator, str)
+	if err != nil {
+		panic(err)
+	}
+	return ptr
+}
+
+// CWStringE is equivalent to CWString, but returns an error instead of panicking if the allocation fails
+func CWStringE(allocator Allocator, str string) (*uint16, error) {
+	unitCount := 0
+	for _, r := range str {
+		if r >= 0x10000 {
+			unitCount += 2
+		} else {
+			unitCount++
+		}
+	}
+
+	ptr, err := TryMalloc(allocator, (unitCount+1)*2)
+	if err != nil {
+		return nil, err
+	}
+	units := unsafe.Slice((*uint16)(ptr), unitCount+1)
+
+	i := 0
+	for _, r := range str {
+		if r >= 0x10000 {
+			high, low := utf16.EncodeRune(r)
+			units[i], units[i+1] = uint16(high), uint16(low)
+			i += 2
+		} else {
+			units[i] = uint16(r)
+			i++
+		}
+	}
+	units[unitCount] = 0
+
+	return (*uint16)(ptr), nil
+}
+
+// GoWString decodes the NUL-terminated UTF-16 string str into a Go string.  Unpaired surrogates are replaced with
+// U+FFFD.  A nil str produces an empty string.
+func GoWString(str *uint16) string {
+	if str == nil {
+		return ""
+	}
+
+	length := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(str), length*2)) != 0 {
+		length++
+	}
+
+	return string(utf16.Decode(unsafe.Slice(str, length)))
+}
+
+// cUTF32String converts str into a NUL-terminated UTF-32 string using the provided Allocator
+func cUTF32String(allocator Allocator, str string) (unsafe.Pointer, error) {
+	runeCount := utf8.RuneCountInString(str)
+
+	ptr, err := TryMalloc(allocator, (runeCount+1)*4)
+	if err != nil {
+		return nil, err
+	}
+	units := unsafe.Slice((*uint32)(ptr), runeCount+1)
+
+	i := 0
+	for _, r := range str {
+		units[i] = uint32(r)
+		i++
+	}
+	units[runeCount] = 0
+
+	return ptr, nil
+}
+
+// goUTF32String decodes the NUL-terminated UTF-32 string at str into a Go string
+func goUTF32String(str unsafe.Pointer) string {
+	length := 0
+	for *(*uint32)(unsafe.Add(str, length*4)) != 0 {
+		length++
+	}
+
+	runes := make([]rune, length)
+	for i, unit := range unsafe.Slice((*uint32)(str), length) {
+		runes[i] = rune(unit)
+	}
+	return string(runes)
+}
@@ -0,0 +1,64 @@
+//go:build cgo
+
+package cgoalloc_test
+
+import (
+	"github.com/CannibalVox/cgoalloc"
+	"github.com/CannibalVox/cgoalloc/cgoalloctest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+func TestWStrings_UTF16(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, &cgoalloc.DefaultAllocator{})
+
+	str := cgoalloc.CWString(testAlloc, "hé\U0001F600")
+	require.Equal(t, []uint16{'h', 0xe9, 0xd83d, 0xde00, 0}, unsafe.Slice(str, 5))
+	require.Equal(t, "hé\U0001F600", cgoalloc.GoWString(str))
+	require.Equal(t, "", cgoalloc.GoWString(nil))
+	testAlloc.Free(unsafe.Pointer(str))
+
+	allocs, frees := testAlloc.Record()
+	require.Equal(t, []int{10}, allocs)
+	require.Equal(t, []int{10}, frees)
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestWStrings_WChar(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, &cgoalloc.DefaultAllocator{})
+
+	str := cgoalloc.CWCharString(testAlloc, "hé\U0001F600")
+	require.Equal(t, "hé\U0001F600", cgoalloc.GoWCharString(str))
+	testAlloc.Free(unsafe.Pointer(str))
+
+	str = nil
+	require.Equal(t, "", cgoalloc.GoWCharString(str))
+	require.NoError(t, testAlloc.Destroy())
+}
+
+func TestWStrings_AllocationFailures(t *testing.T) {
+	testAlloc := cgoalloctest.CreateRecordingAllocator(t, &cgoalloc.DefaultAllocator{})
+	failing := cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: 1})
+
+	_, err := cgoalloc.CWStringE(failing, "hé")
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	failing = cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: 1})
+	require.Panics(t, func() {
+		_ = cgoalloc.CWString(failing, "hé")
+	})
+
+	failing = cgoalloctest.CreateFaultInjectingAllocator(testAlloc, cgoalloctest.FaultOptions{FailOnCall: 1})
+	_, err = cgoalloc.CWCharStringE(failing, "hé")
+	require.ErrorIs(t, err, cgoalloc.ErrOutOfMemory)
+
+	str, err := cgoalloc.CWCharStringE(failing, "hé")
+	require.NoError(t, err)
+	require.Equal(t, "hé", cgoalloc.GoWCharString(str))
+	failing.Free(unsafe.Pointer(str))
+
+	allocs, _ := testAlloc.Record()
+	require.Len(t, allocs, 1)
+	require.NoError(t, testAlloc.Destroy())
+}